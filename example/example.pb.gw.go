@@ -13,9 +13,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	pgiruntime "github.com/tarmalonchik/protoc-gen-interceptors/runtime"
+	"github.com/tarmalonchik/protoc-gen-interceptors/runtime/otelpgi"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/grpclog"
@@ -33,11 +36,15 @@ var _ = runtime.String
 var _ = utilities.NewDoubleArray
 var _ = metadata.Join
 
-func request_AuthService_Auth_0(ctx context.Context, marshaler runtime.Marshaler, client AuthServiceClient, req *http.Request, pathParams map[string]string) (proto.Message, runtime.ServerMetadata, error) {
+func request_AuthService_Auth_0(ctx context.Context, marshaler runtime.Marshaler, client AuthServiceClient, req *http.Request, pathParams map[string]string, options *pgiruntime.Options) (proto.Message, runtime.ServerMetadata, error) {
 	var protoReq emptypb.Empty
 	var metadata runtime.ServerMetadata
 
-	msg, err := client.Auth(ctx, &protoReq, grpc.Header(&metadata.HeaderMD), grpc.Trailer(&metadata.TrailerMD))
+	pgiruntime.AuditRequest(ctx, &protoReq)
+	if err := pgiruntime.ValidateRequest(&protoReq); err != nil {
+		return nil, metadata, err
+	}
+	msg, err := pgiruntime.InvokeUnaryClient(ctx, options, "/example.AuthService/Auth", &protoReq, client.Auth, grpc.Header(&metadata.HeaderMD), grpc.Trailer(&metadata.TrailerMD))
 	return msg, metadata, err
 
 }
@@ -46,6 +53,10 @@ func local_request_AuthService_Auth_0(ctx context.Context, marshaler runtime.Mar
 	var protoReq emptypb.Empty
 	var metadata runtime.ServerMetadata
 
+	pgiruntime.AuditRequest(ctx, &protoReq)
+	if err := pgiruntime.ValidateRequest(&protoReq); err != nil {
+		return nil, metadata, err
+	}
 	msg, err := server.Auth(ctx, &protoReq)
 	return msg, metadata, err
 
@@ -55,7 +66,17 @@ func local_request_AuthService_Auth_0(ctx context.Context, marshaler runtime.Mar
 // UnaryRPC     :call AuthServiceServer directly.
 // StreamingRPC :currently unsupported pending https://github.com/grpc/grpc-go/issues/906.
 // Note that using this registration option will cause many gRPC library features to stop working. Consider using RegisterAuthServiceHandlerFromEndpoint instead.
-func RegisterAuthServiceHandlerServer(ctx context.Context, mux *runtime.ServeMux, server AuthServiceServer, interceptor *grpc.UnaryServerInterceptor) error {
+func RegisterAuthServiceHandlerServer(ctx context.Context, mux *runtime.ServeMux, server AuthServiceServer, interceptor *grpc.UnaryServerInterceptor, opts ...pgiruntime.Option) error {
+	options, err := pgiruntime.NewOptions(append([]pgiruntime.Option{otelpgi.WithTracing(), pgiruntime.WithMetrics(AuthService_MethodCatalog), pgiruntime.WithRecover(), pgiruntime.WithAuthRules(AuthService_AuthRules), pgiruntime.WithMethodCatalog(AuthService_MethodCatalog)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	if err = options.CheckMethodInterceptors("example.AuthService", "Auth"); err != nil {
+		return err
+	}
+	if err = options.CheckNamedInterceptors("auth"); err != nil {
+		return err
+	}
 
 	mux.Handle("GET", pattern_AuthService_Auth_0, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
 		ctx, cancel := context.WithCancel(req.Context())
@@ -70,7 +91,7 @@ func RegisterAuthServiceHandlerServer(ctx context.Context, mux *runtime.ServeMux
 			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
 			return
 		}
-		md, resp, err := interceptor_local_request_AuthService_Auth_0(ctx, annotatedContext, inboundMarshaler, server, interceptor, req, pathParams)
+		md, resp, err := interceptor_local_request_AuthService_Auth_0(annotatedContext, inboundMarshaler, server, interceptor, options, req, pathParams)
 
 		md.HeaderMD, md.TrailerMD = metadata.Join(md.HeaderMD, stream.Header()), metadata.Join(md.TrailerMD, stream.Trailer())
 		annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
@@ -88,8 +109,13 @@ func RegisterAuthServiceHandlerServer(ctx context.Context, mux *runtime.ServeMux
 
 // RegisterAuthServiceHandlerFromEndpoint is same as RegisterAuthServiceHandler but
 // automatically dials to "endpoint" and closes the connection when "ctx" gets done.
-func RegisterAuthServiceHandlerFromEndpoint(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) (err error) {
-	conn, err := grpc.Dial(endpoint, opts...)
+func RegisterAuthServiceHandlerFromEndpoint(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption, pgiOpts ...pgiruntime.Option) (err error) {
+	options, err := pgiruntime.NewOptions(pgiOpts...)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(endpoint, append(opts, options.DialOptions()...)...)
 	if err != nil {
 		return err
 	}
@@ -108,13 +134,13 @@ func RegisterAuthServiceHandlerFromEndpoint(ctx context.Context, mux *runtime.Se
 		}()
 	}()
 
-	return RegisterAuthServiceHandler(ctx, mux, conn)
+	return RegisterAuthServiceHandler(ctx, mux, conn, append([]pgiruntime.Option{pgiruntime.WithDialedClientInterceptors()}, pgiOpts...)...)
 }
 
 // RegisterAuthServiceHandler registers the http handlers for service AuthService to "mux".
 // The handlers forward requests to the grpc endpoint over "conn".
-func RegisterAuthServiceHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
-	return RegisterAuthServiceHandlerClient(ctx, mux, NewAuthServiceClient(conn))
+func RegisterAuthServiceHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn, opts ...pgiruntime.Option) error {
+	return RegisterAuthServiceHandlerClient(ctx, mux, NewAuthServiceClient(conn), append([]pgiruntime.Option{pgiruntime.WithClientConn(conn)}, opts...)...)
 }
 
 // RegisterAuthServiceHandlerClient registers the http handlers for service AuthService
@@ -122,7 +148,17 @@ func RegisterAuthServiceHandler(ctx context.Context, mux *runtime.ServeMux, conn
 // Note: the gRPC framework executes interceptors within the gRPC handler. If the passed in "AuthServiceClient"
 // doesn't go through the normal gRPC flow (creating a gRPC client etc.) then it will be up to the passed in
 // "AuthServiceClient" to call the correct interceptors.
-func RegisterAuthServiceHandlerClient(ctx context.Context, mux *runtime.ServeMux, client AuthServiceClient) error {
+func RegisterAuthServiceHandlerClient(ctx context.Context, mux *runtime.ServeMux, client AuthServiceClient, opts ...pgiruntime.Option) error {
+	options, err := pgiruntime.NewOptions(append([]pgiruntime.Option{otelpgi.WithTracing(), pgiruntime.WithMetrics(AuthService_MethodCatalog), pgiruntime.WithRecover(), pgiruntime.WithAuthRules(AuthService_AuthRules), pgiruntime.WithMethodCatalog(AuthService_MethodCatalog)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	if err = options.CheckMethodInterceptors("example.AuthService", "Auth"); err != nil {
+		return err
+	}
+	if err = options.CheckNamedInterceptors("auth"); err != nil {
+		return err
+	}
 
 	mux.Handle("GET", pattern_AuthService_Auth_0, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
 		ctx, cancel := context.WithCancel(req.Context())
@@ -135,7 +171,9 @@ func RegisterAuthServiceHandlerClient(ctx context.Context, mux *runtime.ServeMux
 			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
 			return
 		}
-		resp, md, err := request_AuthService_Auth_0(annotatedContext, inboundMarshaler, client, req, pathParams)
+		resp, md, err := pgiruntime.InterceptUnaryRequest(annotatedContext, options, "/example.AuthService/Auth", req, pathParams, func(ctx context.Context) (proto.Message, runtime.ServerMetadata, error) {
+			return request_AuthService_Auth_0(ctx, inboundMarshaler, client, req, pathParams, options)
+		}, "auth")
 		annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
 		if err != nil {
 			runtime.HTTPError(annotatedContext, mux, outboundMarshaler, w, req, err)
@@ -157,33 +195,49 @@ var (
 	forward_AuthService_Auth_0 = runtime.ForwardResponseMessage
 )
 
-func interceptor_local_request_AuthService_Auth_0(ctx, annotatedContext context.Context, inboundMarshaler runtime.Marshaler, server AuthServiceServer, interceptor *grpc.UnaryServerInterceptor, req *http.Request, pathParams map[string]string) (md runtime.ServerMetadata, resp proto.Message, err error) {
-	type handlerResponse struct {
-		md   runtime.ServerMetadata
-		resp proto.Message
-	}
-	handler := func(ctx context.Context, req interface {
-	}) (interface {
-	}, error) {
+func interceptor_local_request_AuthService_Auth_0(annotatedContext context.Context, inboundMarshaler runtime.Marshaler, server AuthServiceServer, interceptor *grpc.UnaryServerInterceptor, options *pgiruntime.Options, req *http.Request, pathParams map[string]string) (md runtime.ServerMetadata, resp proto.Message, err error) {
+	annotatedContext = pgiruntime.NewGatewayContext(annotatedContext, "/example.AuthService/Auth", req, pathParams, options)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		if req, ok := req.(*http.Request); ok {
-			resp, md, err := local_request_AuthService_Auth_0(annotatedContext, inboundMarshaler, server, req, pathParams)
-			return handlerResponse{resp: resp, md: md}, err
+			resp, md, err := local_request_AuthService_Auth_0(ctx, inboundMarshaler, server, req, pathParams)
+			return pgiruntime.HandlerResponse{Resp: resp, MD: md}, err
 		}
 		return nil, fmt.Errorf("error converting req to *http.Request")
 	}
-	var handlerResponseItem interface {
-	}
-	if interceptor == nil {
-		handlerResponseItem, err = handler(ctx, req)
+	var handlerResponseItem interface{}
+	chain := options.UnaryServerInterceptor("/example.AuthService/Auth", interceptor, "auth")
+	if chain == nil {
+		handlerResponseItem, err = handler(annotatedContext, req)
 	} else {
-		handlerResponseItem, err = (*interceptor)(ctx, req, &grpc.UnaryServerInfo{Server: server, FullMethod: "/example.AuthService/Auth"}, handler)
+		handlerResponseItem, err = chain(annotatedContext, req, &grpc.UnaryServerInfo{Server: server, FullMethod: "/example.AuthService/Auth"}, handler)
 	}
-	if err != nil {
-		return
-	}
-	data, ok := handlerResponseItem.(handlerResponse)
+	data, ok := handlerResponseItem.(pgiruntime.HandlerResponse)
 	if !ok {
 		return
 	}
-	return data.md, data.resp, nil
+	return data.MD, data.Resp, err
+}
+
+// RegisterAuthServiceServerAndHandler registers "server" on "s" and the http handlers for service AuthService to "mux".
+// Both transports use the interceptors passed with "opts".
+func RegisterAuthServiceServerAndHandler(ctx context.Context, s grpc.ServiceRegistrar, mux *runtime.ServeMux, server AuthServiceServer, opts ...pgiruntime.Option) error {
+	if err := pgiruntime.RegisterService(s, &AuthService_ServiceDesc, server, map[string][]string{"/example.AuthService/Auth": {"auth"}}, append([]pgiruntime.Option{otelpgi.WithTracing(), pgiruntime.WithMetrics(AuthService_MethodCatalog), pgiruntime.WithRecover(), pgiruntime.WithAuthRules(AuthService_AuthRules), pgiruntime.WithMethodCatalog(AuthService_MethodCatalog)}, opts...)...); err != nil {
+		return err
+	}
+	return RegisterAuthServiceHandlerServer(ctx, mux, server, nil, opts...)
+}
+
+// Full method names of service AuthService.
+const (
+	AuthService_Auth_FullMethodName = "/example.AuthService/Auth"
+)
+
+// AuthService_MethodCatalog lists the methods of service AuthService with their HTTP bindings.
+var AuthService_MethodCatalog = []pgiruntime.MethodInfo{
+	{FullMethod: AuthService_Auth_FullMethodName, HTTPVerb: "GET", Pattern: "/v1/example", RequestType: "google.protobuf.Empty", ResponseType: "google.protobuf.Empty", Timeout: 5 * time.Second, MaxRequestBytes: 1048576},
+}
+
+// AuthService_AuthRules lists the authorization rules declared for service AuthService.
+var AuthService_AuthRules = []pgiruntime.AuthRule{
+	{FullMethod: AuthService_Auth_FullMethodName, Scopes: []string{"example.read"}},
 }
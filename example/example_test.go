@@ -0,0 +1,319 @@
+package example
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	pgiruntime "github.com/tarmalonchik/protoc-gen-interceptors/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type authServer struct {
+	UnimplementedAuthServiceServer
+}
+
+func (authServer) Auth(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+type allowAll struct{}
+
+func (allowAll) Principal(context.Context) (interface{}, error) {
+	return "user", nil
+}
+
+func (allowAll) Authorize(context.Context, interface{}, *pgiruntime.AuthRule) error {
+	return nil
+}
+
+// newTestMux registers AuthService on the Register*HandlerServer path with the "auth" interceptor.
+func newTestMux(t *testing.T, auth grpc.UnaryServerInterceptor, opts ...pgiruntime.Option) *runtime.ServeMux {
+	t.Helper()
+	return newTestServerMux(t, authServer{}, auth, opts...)
+}
+
+// newTestServerMux registers server as AuthService on the Register*HandlerServer path with the "auth" interceptor.
+func newTestServerMux(t *testing.T, server AuthServiceServer, auth grpc.UnaryServerInterceptor, opts ...pgiruntime.Option) *runtime.ServeMux {
+	t.Helper()
+	mux := runtime.NewServeMux()
+	opts = append([]pgiruntime.Option{
+		pgiruntime.WithAuthorizer(allowAll{}),
+		pgiruntime.WithNamedInterceptor("auth", auth),
+	}, opts...)
+	if err := RegisterAuthServiceHandlerServer(context.Background(), mux, server, nil, opts...); err != nil {
+		t.Fatalf("registering handler: %v", err)
+	}
+	return mux
+}
+
+func serveAuth(mux *runtime.ServeMux) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+	req.Header.Set("TE", "trailers")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestInterceptorMetadataIsForwarded(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "success", status: http.StatusOK},
+		{name: "error", err: status.Error(codes.Unauthenticated, "no token"), status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := newTestMux(t, func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := grpc.SetHeader(ctx, metadata.Pairs("www-authenticate", "Bearer")); err != nil {
+					t.Errorf("setting header: %v", err)
+				}
+				if err := grpc.SetTrailer(ctx, metadata.Pairs("x-trailer", "done")); err != nil {
+					t.Errorf("setting trailer: %v", err)
+				}
+				if tc.err != nil {
+					return nil, tc.err
+				}
+				return handler(ctx, req)
+			})
+
+			rec := serveAuth(mux)
+			if rec.Code != tc.status {
+				t.Fatalf("status is %d, want %d: %s", rec.Code, tc.status, rec.Body.String())
+			}
+			if got := rec.Header().Get(runtime.MetadataHeaderPrefix + "Www-Authenticate"); got != "Bearer" {
+				t.Errorf("header is %q, want %q", got, "Bearer")
+			}
+			if got := rec.Header().Get(runtime.MetadataTrailerPrefix + "X-Trailer"); got != "done" {
+				t.Errorf("trailer is %q, want %q", got, "done")
+			}
+		})
+	}
+}
+
+// newTestConn serves AuthService over an in-memory listener and returns the connection to it.
+func newTestConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterAuthServiceServer(server, authServer{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func passThrough(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(ctx, req)
+}
+
+func TestClientInterceptorsGetConn(t *testing.T) {
+	conn := newTestConn(t)
+	var calls int
+	mux := runtime.NewServeMux()
+	err := RegisterAuthServiceHandler(context.Background(), mux, conn,
+		pgiruntime.WithAuthorizer(allowAll{}),
+		pgiruntime.WithNamedInterceptor("auth", passThrough),
+		pgiruntime.WithUnaryClientInterceptors(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			calls++
+			if cc != conn {
+				t.Errorf("interceptor got connection %v, want %v", cc, conn)
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("registering handler: %v", err)
+	}
+
+	if rec := serveAuth(mux); rec.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if calls != 1 {
+		t.Errorf("interceptor is called %d times, want 1", calls)
+	}
+}
+
+func TestClientInterceptorsAreDialedFromEndpoint(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	server := grpc.NewServer()
+	RegisterAuthServiceServer(server, authServer{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var calls int
+	mux := runtime.NewServeMux()
+	err = RegisterAuthServiceHandlerFromEndpoint(ctx, mux, listener.Addr().String(),
+		[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		pgiruntime.WithAuthorizer(allowAll{}),
+		pgiruntime.WithNamedInterceptor("auth", passThrough),
+		pgiruntime.WithUnaryClientInterceptors(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			calls++
+			if cc == nil {
+				t.Error("interceptor got nil connection")
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("registering handler: %v", err)
+	}
+
+	if rec := serveAuth(mux); rec.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if calls != 1 {
+		t.Errorf("interceptor is called %d times, want 1", calls)
+	}
+}
+
+func TestMethodInterceptorsOfUnknownMethods(t *testing.T) {
+	for _, tc := range []struct {
+		fullMethod string
+		wantErr    bool
+	}{
+		{fullMethod: "/example.AuthService/Auth"},
+		{fullMethod: "/example.AuthService/Login", wantErr: true},
+		{fullMethod: "/example.AuthServce/Auth", wantErr: true},
+	} {
+		t.Run(tc.fullMethod, func(t *testing.T) {
+			err := RegisterAuthServiceHandlerServer(context.Background(), runtime.NewServeMux(), authServer{}, nil,
+				pgiruntime.WithAuthorizer(allowAll{}),
+				pgiruntime.WithNamedInterceptor("auth", passThrough),
+				pgiruntime.WithMethodInterceptors(map[string][]grpc.UnaryServerInterceptor{tc.fullMethod: {passThrough}}),
+			)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("registration error is %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+type panickingAuthServer struct {
+	UnimplementedAuthServiceServer
+}
+
+func (panickingAuthServer) Auth(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	panic("boom")
+}
+
+func TestPanicsAreRecoveredByChain(t *testing.T) {
+	vars := new(expvar.Map).Init()
+	var recovered interface{}
+	mux := newTestServerMux(t, panickingAuthServer{}, passThrough,
+		pgiruntime.WithCollector(pgiruntime.NewExpvarCollector(vars)),
+		pgiruntime.WithPanicHandler(func(_ context.Context, val interface{}, _ []byte) {
+			recovered = val
+		}),
+	)
+
+	rec := serveAuth(mux)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status is %d, want %d: %s", rec.Code, http.StatusInternalServerError, rec.Body.String())
+	}
+	if recovered != "boom" {
+		t.Errorf("panic handler got %v, want %q", recovered, "boom")
+	}
+	method, ok := vars.Get("/example.AuthService/Auth").(*expvar.Map)
+	if !ok {
+		t.Fatal("no metrics of the method")
+	}
+	if got := method.Get("codes").(*expvar.Map).Get(codes.Internal.String()); got == nil || got.String() != "1" {
+		t.Errorf("%s calls are %v, want 1", codes.Internal, got)
+	}
+}
+
+func TestRegistrationRequiresAuthorizer(t *testing.T) {
+	// AuthService declares (pgi.auth), so the handlers are not registered without the authorizer
+	err := RegisterAuthServiceHandlerServer(context.Background(), runtime.NewServeMux(), authServer{}, nil,
+		pgiruntime.WithNamedInterceptor("auth", passThrough))
+	if err == nil || !strings.Contains(err.Error(), "require WithAuthorizer") {
+		t.Fatalf("error is %v, want the one about the missing authorizer", err)
+	}
+}
+
+func TestContextAccessors(t *testing.T) {
+	var checked bool
+	mux := newTestMux(t, func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		checked = true
+		if !pgiruntime.IsGatewayCall(ctx) {
+			t.Error("call is not reported as the gateway one")
+		}
+		if httpReq, ok := pgiruntime.HTTPRequestFromContext(ctx); !ok || httpReq != req {
+			t.Errorf("HTTP request is %v, want the one passed to the interceptor", httpReq)
+		} else if httpReq.URL.Path != "/v1/example" {
+			t.Errorf("HTTP request path is %s, want %s", httpReq.URL.Path, "/v1/example")
+		}
+		// the pattern of AuthService.Auth has no variables
+		if params, ok := pgiruntime.PathParamsFromContext(ctx); !ok || len(params) != 0 {
+			t.Errorf("path params are %v, %t, want empty ones", params, ok)
+		}
+		if pattern, ok := pgiruntime.HTTPPatternFromContext(ctx); !ok || pattern != "/v1/example" {
+			t.Errorf("HTTP pattern is %q, %t, want %q", pattern, ok, "/v1/example")
+		}
+
+		info, ok := pgiruntime.CallInfoFromContext(ctx)
+		if !ok {
+			t.Error("no call info")
+			return handler(ctx, req)
+		}
+		if info.FullMethod != AuthService_Auth_FullMethodName {
+			t.Errorf("full method is %s, want %s", info.FullMethod, AuthService_Auth_FullMethodName)
+		}
+		if info.HTTPMethod != http.MethodGet || info.HTTPPattern != "/v1/example" || len(info.PathParams) != 0 {
+			t.Errorf("HTTP binding is %s %s %v, want %s %s", info.HTTPMethod, info.HTTPPattern, info.PathParams, http.MethodGet, "/v1/example")
+		}
+		if info.Method == nil || info.Method.FullName() != "example.AuthService.Auth" {
+			t.Errorf("method descriptor is %v, want the one of example.AuthService.Auth", info.Method)
+		} else if input := info.Method.Input().FullName(); input != "google.protobuf.Empty" {
+			t.Errorf("input of method descriptor is %s, want %s", input, "google.protobuf.Empty")
+		}
+		return handler(ctx, req)
+	})
+
+	if rec := serveAuth(mux); rec.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if !checked {
+		t.Fatal("interceptor is not called")
+	}
+	if pgiruntime.IsGatewayCall(context.Background()) {
+		t.Error("context without the call is reported as the gateway one")
+	}
+	if _, ok := pgiruntime.CallInfoFromContext(context.Background()); ok {
+		t.Error("context without the call has call info")
+	}
+}
@@ -0,0 +1,281 @@
+package pgi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	serviceNamePlaceholder = "{service}"
+
+	interceptWrapperMode = "intercept"
+	directWrapperMode    = "direct"
+)
+
+// Config is the configuration file passed with config=<path>, it is YAML or JSON.
+// The settings of the service are the nearest ones: the service, the package, the top level
+// of the file, then the plugin parameters.
+type Config struct {
+	Settings `yaml:",inline"`
+	// Packages override the settings for proto packages.
+	Packages []PackageConfig `yaml:"packages"`
+}
+
+// PackageConfig is the settings of a proto package.
+type PackageConfig struct {
+	// Name is the proto package, e.g. "example".
+	Name     string `yaml:"name"`
+	Settings `yaml:",inline"`
+	// Services override the settings for services of the package.
+	Services []ServiceConfig `yaml:"services"`
+}
+
+// ServiceConfig is the settings of a service.
+type ServiceConfig struct {
+	// Name is the service name without the package, e.g. "AuthService".
+	Name     string `yaml:"name"`
+	Settings `yaml:",inline"`
+}
+
+// Settings are the settings of one level of Config, the ones which are not set are inherited.
+type Settings struct {
+	// Include and Exclude replace the inherited glob patterns. The patterns are matched against
+	// the method names relative to the level: full names without the leading slash at the top level,
+	// "Service/Method" in packages and method names in services.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Wrapper is "intercept" to call the gateway handlers through the interceptors
+	// or "direct" to call them directly as the excluded methods do.
+	Wrapper string `yaml:"wrapper"`
+	// TemplateDir is the directory with wrapper.go.tmpl, relative to the file.
+	TemplateDir string `yaml:"template_dir"`
+	// Naming sets the names of the declarations generated for services.
+	Naming Naming `yaml:"naming"`
+	// Features enable the features of the plugin parameters.
+	Features Features `yaml:"features"`
+}
+
+// Naming sets the names of the declarations generated for services, "{service}" is replaced by the service name.
+type Naming struct {
+	Registration  string `yaml:"registration"`
+	MethodCatalog string `yaml:"method_catalog"`
+	AuthRules     string `yaml:"auth_rules"`
+}
+
+// Features are the plugin parameters which can be set for packages and services.
+type Features struct {
+	ClientInterceptors *bool  `yaml:"client_interceptors"`
+	Recover            *bool  `yaml:"recover"`
+	Validate           *bool  `yaml:"validate"`
+	MaxRequestBytes    *int64 `yaml:"max_request_bytes"`
+	Metrics            *bool  `yaml:"metrics"`
+	Tracing            *bool  `yaml:"tracing"`
+	Audit              *bool  `yaml:"audit"`
+}
+
+// LoadConfig reads and validates the configuration file. Errors point to the line of the file
+// or the path of the invalid setting. Files without settings are rejected, they are likely truncated or wrong.
+func LoadConfig(name string) (*Config, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: config has no settings", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err = config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	config.resolvePaths(filepath.Dir(name))
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if err := c.Settings.validate("", true); err != nil {
+		return err
+	}
+	packages := make(map[string]interface{})
+	for i, pkg := range c.Packages {
+		field := fmt.Sprintf("packages[%d]", i)
+		if pkg.Name == "" {
+			return fmt.Errorf("%s.name is required", field)
+		}
+		if _, ok := packages[pkg.Name]; ok {
+			return fmt.Errorf("%s.name: package %s is listed twice", field, pkg.Name)
+		}
+		packages[pkg.Name] = nil
+		if err := pkg.Settings.validate(field+".", true); err != nil {
+			return err
+		}
+		services := make(map[string]interface{})
+		for j, service := range pkg.Services {
+			field := fmt.Sprintf("%s.services[%d]", field, j)
+			if service.Name == "" || strings.ContainsAny(service.Name, "./") {
+				return fmt.Errorf("%s.name: invalid service name %q, the name is given without the package", field, service.Name)
+			}
+			if _, ok := services[service.Name]; ok {
+				return fmt.Errorf("%s.name: service %s is listed twice", field, service.Name)
+			}
+			services[service.Name] = nil
+			// the names of a single service do not need the placeholder
+			if err := service.Settings.validate(field+".", false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s Settings) validate(prefix string, sharedNames bool) error {
+	for i, pattern := range s.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s%s[%d]: invalid pattern %q: %w", prefix, includeParam, i, pattern, err)
+		}
+	}
+	for i, pattern := range s.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s%s[%d]: invalid pattern %q: %w", prefix, excludeParam, i, pattern, err)
+		}
+	}
+	switch s.Wrapper {
+	case "", interceptWrapperMode, directWrapperMode:
+	default:
+		return fmt.Errorf("%swrapper: unknown mode %q, expected %q or %q", prefix, s.Wrapper, interceptWrapperMode, directWrapperMode)
+	}
+	for _, naming := range []struct{ key, name string }{
+		{key: "registration", name: s.Naming.Registration},
+		{key: "method_catalog", name: s.Naming.MethodCatalog},
+		{key: "auth_rules", name: s.Naming.AuthRules},
+	} {
+		if naming.name == "" {
+			continue
+		}
+		if sharedNames && !strings.Contains(naming.name, serviceNamePlaceholder) {
+			return fmt.Errorf("%snaming.%s: name %q has to contain %s", prefix, naming.key, naming.name, serviceNamePlaceholder)
+		}
+		if !token.IsIdentifier(strings.ReplaceAll(naming.name, serviceNamePlaceholder, "Service")) {
+			return fmt.Errorf("%snaming.%s: name %q is not a Go identifier", prefix, naming.key, naming.name)
+		}
+	}
+	if s.Features.MaxRequestBytes != nil && *s.Features.MaxRequestBytes < 0 {
+		return fmt.Errorf("%sfeatures.%s: negative value %d", prefix, maxRequestBytesParam, *s.Features.MaxRequestBytes)
+	}
+	return nil
+}
+
+// resolvePaths makes the template directories relative to dir of the file.
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(s *Settings) {
+		if s.TemplateDir != "" && !filepath.IsAbs(s.TemplateDir) {
+			s.TemplateDir = filepath.Join(dir, s.TemplateDir)
+		}
+	}
+	resolve(&c.Settings)
+	for i := range c.Packages {
+		resolve(&c.Packages[i].Settings)
+		for j := range c.Packages[i].Services {
+			resolve(&c.Packages[i].Services[j].Settings)
+		}
+	}
+}
+
+// forService returns the options of service with the settings of Config applied.
+func (p Options) forService(pkg, service string) Options {
+	if p.Config == nil {
+		return p
+	}
+	resp := p
+	resp.Config = nil
+	resp.apply(p.Config.Settings, "")
+	for _, pkgConfig := range p.Config.Packages {
+		if pkgConfig.Name != pkg {
+			continue
+		}
+		resp.apply(pkgConfig.Settings, pkg+".")
+		for _, serviceConfig := range pkgConfig.Services {
+			if serviceConfig.Name == service {
+				resp.apply(serviceConfig.Settings, resolveFullServiceName(pkg, service)+"/")
+			}
+		}
+	}
+	return resp
+}
+
+// forMethod returns the options of the service of full gRPC method name.
+func (p Options) forMethod(fullMethod string) Options {
+	if p.Config == nil {
+		return p
+	}
+	fullService, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	var pkg string
+	service := fullService
+	if i := strings.LastIndex(fullService, "."); i >= 0 {
+		pkg, service = fullService[:i], fullService[i+1:]
+	}
+	return p.forService(pkg, service)
+}
+
+// apply overrides the options with the settings, prefix makes the patterns relative to the level full.
+func (p *Options) apply(s Settings, prefix string) {
+	if s.Include != nil {
+		p.Include = prefixPatterns(prefix, s.Include)
+	}
+	if s.Exclude != nil {
+		p.Exclude = prefixPatterns(prefix, s.Exclude)
+	}
+	switch s.Wrapper {
+	case interceptWrapperMode:
+		p.direct = false
+	case directWrapperMode:
+		p.direct = true
+	}
+	if s.TemplateDir != "" {
+		p.TemplateDir = s.TemplateDir
+	}
+	if s.Naming.Registration != "" {
+		p.RegistrationName = s.Naming.Registration
+	}
+	if s.Naming.MethodCatalog != "" {
+		p.MethodCatalogName = s.Naming.MethodCatalog
+	}
+	if s.Naming.AuthRules != "" {
+		p.AuthRulesName = s.Naming.AuthRules
+	}
+	applyFeature(&p.ClientInterceptors, s.Features.ClientInterceptors)
+	applyFeature(&p.Recover, s.Features.Recover)
+	applyFeature(&p.Validate, s.Features.Validate)
+	applyFeature(&p.MaxRequestBytes, s.Features.MaxRequestBytes)
+	applyFeature(&p.Metrics, s.Features.Metrics)
+	applyFeature(&p.Tracing, s.Features.Tracing)
+	applyFeature(&p.Audit, s.Features.Audit)
+}
+
+func applyFeature[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func prefixPatterns(prefix string, patterns []string) []string {
+	resp := make([]string, len(patterns))
+	for i := range patterns {
+		resp[i] = prefix + patterns[i]
+	}
+	return resp
+}
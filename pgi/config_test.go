@@ -0,0 +1,187 @@
+package pgi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "pgi.yaml")
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return name
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "empty", config: "", err: "config has no settings"},
+		{name: "comments only", config: "# nothing yet\n", err: "config has no settings"},
+		{name: "unknown field", config: "features:\n  recovery: true\n", err: "line 2: field recovery not found"},
+		{name: "unknown package field", config: "packages:\n  - name: example\n    wraper: direct\n", err: "line 3: field wraper not found"},
+		{name: "package without name", config: "packages:\n  - wrapper: direct\n", err: "packages[0].name is required"},
+		{
+			name:   "duplicate package",
+			config: "packages:\n  - name: example\n  - name: other\n  - name: example\n",
+			err:    "packages[2].name: package example is listed twice",
+		},
+		{
+			name:   "duplicate service",
+			config: "packages:\n  - name: example\n    services:\n      - name: AuthService\n      - name: AuthService\n",
+			err:    "packages[0].services[1].name: service AuthService is listed twice",
+		},
+		{
+			name:   "service with package",
+			config: "packages:\n  - name: example\n    services:\n      - name: example.AuthService\n",
+			err:    `packages[0].services[0].name: invalid service name "example.AuthService"`,
+		},
+		{name: "naming without placeholder", config: "naming:\n  registration: Register\n", err: `naming.registration: name "Register" has to contain {service}`},
+		{
+			name:   "package naming without placeholder",
+			config: "packages:\n  - name: example\n    naming:\n      auth_rules: Rules\n",
+			err:    `packages[0].naming.auth_rules: name "Rules" has to contain {service}`,
+		},
+		{name: "naming not identifier", config: "naming:\n  method_catalog: \"{service}-Methods\"\n", err: "is not a Go identifier"},
+		{name: "bad pattern", config: "include: [\"example.[\"]\n", err: `include[0]: invalid pattern "example.["`},
+		{name: "unknown wrapper", config: "wrapper: wrap\n", err: `wrapper: unknown mode "wrap"`},
+		{name: "negative body limit", config: "features:\n  max_request_bytes: -1\n", err: "features.max_request_bytes: negative value -1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tc.config))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("error is %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	name := writeConfig(t, `
+template_dir: templates
+packages:
+  - name: example
+    template_dir: /abs/templates
+    services:
+      - name: AuthService
+        template_dir: ../auth
+        # a single service does not need the placeholder
+        naming:
+          registration: RegisterAuth
+`)
+	config, err := LoadConfig(name)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	dir := filepath.Dir(name)
+	for _, tc := range []struct{ got, want string }{
+		{got: config.TemplateDir, want: filepath.Join(dir, "templates")},
+		{got: config.Packages[0].TemplateDir, want: "/abs/templates"},
+		{got: config.Packages[0].Services[0].TemplateDir, want: filepath.Join(filepath.Dir(dir), "auth")},
+		{got: config.Packages[0].Services[0].Naming.Registration, want: "RegisterAuth"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("setting is %q, want %q", tc.got, tc.want)
+		}
+	}
+}
+
+func TestOptionsForService(t *testing.T) {
+	enabled, disabled := true, false
+	params := Options{Recover: true, Validate: true, Include: []string{"*"}, TemplateDir: "params"}
+	params.Config = &Config{
+		Settings: Settings{
+			Exclude:     []string{"*.HealthService/*"},
+			TemplateDir: "top",
+			Features:    Features{Validate: &disabled, Metrics: &enabled},
+		},
+		Packages: []PackageConfig{{
+			Name: "example",
+			Settings: Settings{
+				Include:  []string{"Auth*/*"},
+				Wrapper:  directWrapperMode,
+				Naming:   Naming{Registration: "Register{service}"},
+				Features: Features{Metrics: &disabled},
+			},
+			Services: []ServiceConfig{{
+				Name: "AuthService",
+				Settings: Settings{
+					Exclude:     []string{"Debug*"},
+					Wrapper:     interceptWrapperMode,
+					TemplateDir: "service",
+					Features:    Features{Validate: &enabled},
+				},
+			}},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		pkg     string
+		service string
+		want    Options
+	}{
+		{
+			name:    "parameters and top level",
+			pkg:     "other",
+			service: "AuthService",
+			want: Options{
+				Recover: true, Metrics: true, Include: []string{"*"}, Exclude: []string{"*.HealthService/*"},
+				TemplateDir: "top",
+			},
+		},
+		{
+			name:    "package",
+			pkg:     "example",
+			service: "UserService",
+			want: Options{
+				Recover: true, Include: []string{"example.Auth*/*"}, Exclude: []string{"*.HealthService/*"},
+				TemplateDir: "top", RegistrationName: "Register{service}", direct: true,
+			},
+		},
+		{
+			name:    "service",
+			pkg:     "example",
+			service: "AuthService",
+			want: Options{
+				Recover: true, Validate: true, Include: []string{"example.Auth*/*"}, Exclude: []string{"example.AuthService/Debug*"},
+				TemplateDir: "service", RegistrationName: "Register{service}",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := params.forService(tc.pkg, tc.service)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("options are %+v, want %+v", got, tc.want)
+			}
+			if viaMethod := params.forMethod("/" + tc.pkg + "." + tc.service + "/Get"); !reflect.DeepEqual(viaMethod, got) {
+				t.Errorf("options of the method are %+v, want %+v", viaMethod, got)
+			}
+		})
+	}
+}
+
+func TestPrefixPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		prefix   string
+		patterns []string
+		want     []string
+	}{
+		{prefix: "", patterns: []string{"*.Health/*"}, want: []string{"*.Health/*"}},
+		{prefix: "example.", patterns: []string{"Auth*/*", "User/Get"}, want: []string{"example.Auth*/*", "example.User/Get"}},
+		{prefix: "example.AuthService/", patterns: []string{"Debug*"}, want: []string{"example.AuthService/Debug*"}},
+		// an empty list replaces the inherited patterns
+		{prefix: "example.", patterns: []string{}, want: []string{}},
+	} {
+		if got := prefixPatterns(tc.prefix, tc.patterns); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("prefixPatterns(%q, %q) = %q, want %q", tc.prefix, tc.patterns, got, tc.want)
+		}
+	}
+}
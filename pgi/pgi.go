@@ -0,0 +1,244 @@
+// Package pgi rewrites the files generated by grpc-gateway, so the local handlers call
+// the gRPC methods through the interceptors of github.com/tarmalonchik/protoc-gen-interceptors/runtime.
+// It is the implementation of the protoc-gen-interceptors plugin, build tools may use it directly.
+package pgi
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Options configures the transformation, the fields match the plugin parameters.
+type Options struct {
+	// OutDir is the directory with the generated files, it is used by Run only.
+	OutDir string
+	// ClientInterceptors makes Register*HandlerClient call the gRPC client through the client interceptors.
+	ClientInterceptors bool
+	// Include limits the intercepted methods to the ones matching any of the glob patterns.
+	Include []string
+	// Exclude removes the methods matching any of the glob patterns from the intercepted ones.
+	Exclude []string
+	// Recover turns panics of the gateway calls into codes.Internal errors.
+	Recover bool
+	// Validate checks the decoded requests with their Validate methods.
+	Validate bool
+	// MaxRequestBytes limits the size of the request bodies, zero means no limit.
+	MaxRequestBytes int64
+	// Metrics adds the metrics of the calls to the generated options.
+	Metrics bool
+	// Tracing adds the tracing of the calls to the generated options.
+	Tracing bool
+	// Audit passes the decoded requests to the audit records.
+	Audit bool
+	// TemplateDir is the directory with wrapper.go.tmpl rendering the wrappers instead of the built-in template.
+	TemplateDir string
+	// RegistrationName, MethodCatalogName and AuthRulesName are the names of the declarations generated
+	// for services with "{service}" replaced by the service name. The defaults are
+	// "Register{service}ServerAndHandler", "{service}_MethodCatalog" and "{service}_AuthRules".
+	RegistrationName  string
+	MethodCatalogName string
+	AuthRulesName     string
+	// Config overrides the options for packages and services, it is loaded from config=<path>.
+	Config *Config
+
+	// direct makes the gateway handlers of the service call the local methods directly, it is set by Config.
+	direct bool
+
+	// GRPCSource is the file generated by protoc-gen-go-grpc for the same proto file,
+	// the names declared there are not generated again. Run reads it from OutDir.
+	GRPCSource []byte
+	// Dependencies are the files imported by the proto file, the messages used by the requests
+	// are resolved in them. Run passes all files of the request.
+	Dependencies []*descriptorpb.FileDescriptorProto
+}
+
+func (p Options) registrationName(service string) string {
+	return resolveServiceDeclName(p.RegistrationName, registrationTemplate, service)
+}
+
+func (p Options) methodCatalogName(service string) string {
+	return resolveServiceDeclName(p.MethodCatalogName, methodCatalogTemplate, service)
+}
+
+func (p Options) authRulesName(service string) string {
+	return resolveServiceDeclName(p.AuthRulesName, authRulesTemplate, service)
+}
+
+func resolveServiceDeclName(name, defaultTemplate, service string) string {
+	if name == "" {
+		return fmt.Sprintf(defaultTemplate, service)
+	}
+	return strings.ReplaceAll(name, serviceNamePlaceholder, service)
+}
+
+// ParseOptions parses the plugin parameter, e.g. "outdir=.,recover=true,include=example.*".
+func ParseOptions(in string) (resp Options, err error) {
+	for _, param := range strings.Split(in, ",") {
+		if param == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case outDirParam:
+			resp.OutDir = value
+		case clientInterceptorsParam:
+			if resp.ClientInterceptors, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case recoverParam:
+			if resp.Recover, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case validateParam:
+			if resp.Validate, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case maxRequestBytesParam:
+			if resp.MaxRequestBytes, err = strconv.ParseInt(value, 10, 64); err != nil || resp.MaxRequestBytes < 0 {
+				return resp, fmt.Errorf("invalid value of %s: %q", key, value)
+			}
+		case metricsParam:
+			if resp.Metrics, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case tracingParam:
+			if resp.Tracing, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case auditParam:
+			if resp.Audit, err = strconv.ParseBool(value); err != nil {
+				return resp, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+		case templateDirParam:
+			resp.TemplateDir = value
+		case configParam:
+			if resp.Config, err = LoadConfig(value); err != nil {
+				return resp, err
+			}
+		case includeParam, excludeParam:
+			if _, err = path.Match(value, ""); err != nil {
+				return resp, fmt.Errorf("invalid pattern %q in %s: %w", value, key, err)
+			}
+			if key == includeParam {
+				resp.Include = append(resp.Include, value)
+			} else {
+				resp.Exclude = append(resp.Exclude, value)
+			}
+		default:
+			return resp, fmt.Errorf("unknown parameter %s", key)
+		}
+	}
+	return resp, nil
+}
+
+// Transform rewrites src of the file generated by grpc-gateway for file and returns the formatted result.
+// Files without services are returned as is.
+func Transform(src []byte, file *descriptorpb.FileDescriptorProto, opts Options) ([]byte, error) {
+	if len(file.GetService()) == 0 {
+		return src, nil
+	}
+	singleFile := protoFile{
+		filename: file.GetName(),
+		pkg:      file.GetPackage(),
+		services: file.GetService(),
+		messages: getMessagesMap(append([]*descriptorpb.FileDescriptorProto{file}, opts.Dependencies...)),
+	}
+	return transform(src, singleFile, opts.GRPCSource, opts)
+}
+
+// Run rewrites the files generated by grpc-gateway in OutDir for the files to generate of req.
+// The response has no files of its own, errors are reported in it.
+func Run(req *pluginpb.CodeGeneratorRequest) *pluginpb.CodeGeneratorResponse {
+	resp := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)),
+	}
+	opts, err := ParseOptions(req.GetParameter())
+	if err != nil {
+		resp.Error = proto.String(fmt.Sprintf("parameters error: %v", err))
+		return resp
+	}
+	opts.Dependencies = req.GetProtoFile()
+
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, file := range req.GetProtoFile() {
+		files[file.GetName()] = file
+	}
+	for _, name := range req.GetFileToGenerate() {
+		if err = runFile(files[name], opts); err != nil {
+			resp.Error = proto.String(fmt.Sprintf("%s: %v", name, err))
+			return resp
+		}
+	}
+	return resp
+}
+
+// runFile transforms the file generated by grpc-gateway for file in place,
+// grpc-gateway does not generate it for the files without HTTP bindings.
+func runFile(file *descriptorpb.FileDescriptorProto, opts Options) error {
+	if len(file.GetService()) == 0 {
+		return nil
+	}
+	baseName := resolveProtoFileName(file.GetName())
+	generatedFileName := filepath.Join(opts.OutDir, fmt.Sprintf(generatedFileTemplate, baseName))
+
+	src, err := readOptionalFile(generatedFileName)
+	if err != nil || src == nil {
+		return err
+	}
+	if opts.GRPCSource, err = readOptionalFile(filepath.Join(opts.OutDir, fmt.Sprintf(grpcFileTemplate, baseName))); err != nil {
+		return err
+	}
+
+	formatted, err := Transform(src, file, opts)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(generatedFileName, formatted, 0664); err != nil { // nolint
+		return fmt.Errorf("writing file: %w", err)
+	}
+	return nil
+}
+
+// readOptionalFile returns nil content when the file does not exist.
+func readOptionalFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	return data, nil
+}
+
+// getMessagesMap returns the messages declared in files including the nested ones
+// by full names with the leading dot, as fields and methods refer to them.
+func getMessagesMap(files []*descriptorpb.FileDescriptorProto) map[string]*descriptorpb.DescriptorProto {
+	resp := make(map[string]*descriptorpb.DescriptorProto)
+	for _, file := range files {
+		var prefix string
+		if file.GetPackage() != "" {
+			prefix = "." + file.GetPackage()
+		}
+		addMessages(resp, prefix, file.GetMessageType())
+	}
+	return resp
+}
+
+func addMessages(resp map[string]*descriptorpb.DescriptorProto, prefix string, messages []*descriptorpb.DescriptorProto) {
+	for _, message := range messages {
+		name := prefix + "." + message.GetName()
+		resp[name] = message
+		addMessages(resp, name, message.GetNestedType())
+	}
+}
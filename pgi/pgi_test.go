@@ -0,0 +1,107 @@
+package pgi
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tarmalonchik/protoc-gen-interceptors/example"
+	pgioptions "github.com/tarmalonchik/protoc-gen-interceptors/options"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// exampleParams are the parameters example/example.pb.gw.go is generated with.
+const exampleParams = "client_interceptors=true,recover=true,validate=true,max_request_bytes=1048576,metrics=true,tracing=true,audit=true"
+
+// TestTransformExample checks the output for example/example.proto against the committed example/example.pb.gw.go.
+// The input is the grpc-gateway output for it, the example has to be regenerated when the output changes.
+func TestTransformExample(t *testing.T) {
+	opts, err := ParseOptions(exampleParams)
+	if err != nil {
+		t.Fatalf("parsing options: %v", err)
+	}
+	if opts.GRPCSource, err = os.ReadFile("../example/example_grpc.pb.go"); err != nil {
+		t.Fatalf("reading gRPC source: %v", err)
+	}
+	opts.Dependencies = []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(emptypb.File_google_protobuf_empty_proto),
+		protodesc.ToFileDescriptorProto(annotations.File_google_api_annotations_proto),
+		protodesc.ToFileDescriptorProto(pgioptions.File_options_interceptors_proto),
+	}
+	want, err := os.ReadFile("../example/example.pb.gw.go")
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+
+	got, err := Transform(readTestdata(t, "example/example.pb.gw.go"), protodesc.ToFileDescriptorProto(example.File_example_example_proto), opts)
+	if err != nil {
+		t.Fatalf("transforming: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from example/example.pb.gw.go:\n%s", got)
+	}
+}
+
+func TestTransformReturnsFilesWithoutServices(t *testing.T) {
+	// the source is not parsed, so it is returned even if it is not Go code
+	src := []byte("not transformed")
+	got, err := Transform(src, &descriptorpb.FileDescriptorProto{Name: proto.String("empty.proto")}, Options{Recover: true})
+	if err != nil {
+		t.Fatalf("transforming: %v", err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("output is %q, want the source as is", got)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, tc := range []struct {
+		param string
+		want  func(Options) bool
+		err   string
+	}{
+		{param: "", want: func(o Options) bool { return !o.Recover && o.MaxRequestBytes == 0 }},
+		{param: "outdir=gen,recover=true", want: func(o Options) bool { return o.OutDir == "gen" && o.Recover }},
+		{param: "recover=1,metrics=false", want: func(o Options) bool { return o.Recover && !o.Metrics }},
+		{param: "max_request_bytes=0", want: func(o Options) bool { return o.MaxRequestBytes == 0 }},
+		{param: "max_request_bytes=1024", want: func(o Options) bool { return o.MaxRequestBytes == 1024 }},
+		{
+			param: "include=example.*,include=other.*/Get,exclude=*/Health",
+			want: func(o Options) bool {
+				return strings.Join(o.Include, " ") == "example.* other.*/Get" && strings.Join(o.Exclude, " ") == "*/Health"
+			},
+		},
+		{param: "recover=yes", err: "invalid value of recover"},
+		{param: "client_interceptors=", err: "invalid value of client_interceptors"},
+		{param: "validate=on", err: "invalid value of validate"},
+		{param: "metrics=2", err: "invalid value of metrics"},
+		{param: "tracing=y", err: "invalid value of tracing"},
+		{param: "audit=no", err: "invalid value of audit"},
+		{param: "max_request_bytes=-1", err: `invalid value of max_request_bytes: "-1"`},
+		{param: "max_request_bytes=1k", err: `invalid value of max_request_bytes: "1k"`},
+		{param: "include=example.[", err: `invalid pattern "example.[" in include`},
+		{param: "exclude=[a-", err: `invalid pattern "[a-" in exclude`},
+		{param: "unknown=true", err: "unknown parameter unknown"},
+	} {
+		t.Run(tc.param, func(t *testing.T) {
+			got, err := ParseOptions(tc.param)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error is %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+			if !tc.want(got) {
+				t.Errorf("options are %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package pgi
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+const wrapperTemplateName = "wrapper.go.tmpl"
+
+//go:embed templates/*.go.tmpl
+var builtinTemplates embed.FS
+
+// WrapperData is passed to the wrapper template. The rewritten handlers call the wrapper as
+//
+//	md, resp, err := {{.FuncName}}(annotatedContext, inboundMarshaler, server, interceptor, options, req, pathParams)
+//
+// with the types of templates/wrapper.go.tmpl. The template may use the packages imported by the file
+// generated by grpc-gateway, fmt and pgiruntime.
+type WrapperData struct {
+	// FuncName is the name of the wrapper.
+	FuncName string
+	// ServerType is the server interface of the service, e.g. "AuthServiceServer".
+	ServerType string
+	// FullMethod is the full gRPC method name, e.g. "/example.AuthService/Auth".
+	FullMethod string
+	// InterceptorNames are the named interceptors selected for the method with pgi options.
+	InterceptorNames []string
+	// Call is the statement calling the local handler of grpc-gateway with ctx,
+	// it declares md, resp and err.
+	Call string
+	// Recover is set with recover=true, the panics are recovered by the chain with pgiruntime.WithRecover.
+	Recover bool
+}
+
+// loadWrapperTemplate parses wrapper.go.tmpl from dir, the built-in template is used when dir is empty.
+func loadWrapperTemplate(dir string) (*template.Template, error) {
+	var (
+		src []byte
+		err error
+	)
+	if dir == "" {
+		src, err = builtinTemplates.ReadFile("templates/" + wrapperTemplateName)
+	} else {
+		src, err = os.ReadFile(filepath.Join(dir, wrapperTemplateName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading template: %w", err)
+	}
+	tmpl, err := template.New(wrapperTemplateName).
+		Funcs(template.FuncMap{"quote": strconv.Quote}).
+		Option("missingkey=error").
+		Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderWrappers renders the wrappers of functions sorted by names with the templates of their services.
+// The call sites are rewritten already, so every wrapper has to be the single function with the name they call.
+// serverTypes are the server types of the services by full gRPC method names.
+func renderWrappers(fSet *token.FileSet, functions map[string]assignmentWithRPCMethodName, serverTypes map[string]string, params Options) ([]byte, error) {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := make(map[string]*template.Template)
+	buf := bytes.NewBuffer(nil)
+	for _, name := range names {
+		funcData := functions[name]
+		serviceParams := params.forMethod(funcData.fullMethod)
+		tmpl, ok := templates[serviceParams.TemplateDir]
+		if !ok {
+			var err error
+			if tmpl, err = loadWrapperTemplate(serviceParams.TemplateDir); err != nil {
+				return nil, fmt.Errorf("loading wrapper template: %w", err)
+			}
+			templates[serviceParams.TemplateDir] = tmpl
+		}
+		serverType, ok := serverTypes[funcData.fullMethod]
+		if !ok || serverType == "" {
+			return nil, fmt.Errorf("server type of %s is not resolved", funcData.fullMethod)
+		}
+		call := bytes.NewBuffer(nil)
+		if err := printer.Fprint(call, fSet, funcData.assignStmt); err != nil {
+			return nil, fmt.Errorf("printing call of %s: %w", funcData.fullMethod, err)
+		}
+		data := WrapperData{
+			FuncName:         funcData.funcName,
+			ServerType:       serverType,
+			FullMethod:       funcData.fullMethod,
+			InterceptorNames: funcData.interceptorNames,
+			Call:             call.String(),
+			Recover:          serviceParams.Recover,
+		}
+		wrapper := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(wrapper, data); err != nil {
+			return nil, fmt.Errorf("executing template: %w", err)
+		}
+		if err := checkWrapper(wrapper.Bytes(), funcData.funcName); err != nil {
+			return nil, fmt.Errorf("wrapper of %s: %w", funcData.fullMethod, err)
+		}
+		buf.WriteString("\n")
+		buf.Write(wrapper.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// checkWrapper parses the rendered wrapper as Go code, the line directive makes the positions
+// in errors relative to it.
+func checkWrapper(src []byte, funcName string) error {
+	header := fmt.Sprintf("package pgi\n/*line %s:1:1*/", wrapperTemplateName)
+	fileAst, err := parser.ParseFile(token.NewFileSet(), "", append([]byte(header), src...), 0)
+	if err != nil {
+		return fmt.Errorf("parsing rendered template: %w", err)
+	}
+	if len(fileAst.Decls) == 1 {
+		if funcDecl, ok := fileAst.Decls[0].(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == funcName {
+			return nil
+		}
+	}
+	return fmt.Errorf("rendered template has to declare the single function %s", funcName)
+}
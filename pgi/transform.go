@@ -0,0 +1,1783 @@
+package pgi
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pgioptions "github.com/tarmalonchik/protoc-gen-interceptors/options"
+	"golang.org/x/tools/go/ast/astutil"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	protoExtension = ".proto"
+
+	generatedFileTemplate     = "%s.pb.gw.go"
+	rootFunctionTemplate      = "Register%sHandlerServer"
+	methodFunctionTemplate    = "local_request_%s_%s_0"
+	generatedFunctionTemplate = "%s_%s"
+	clientRootTemplate        = "Register%sHandlerClient"
+	connRootTemplate          = "Register%sHandler"
+	endpointRootTemplate      = "Register%sHandlerFromEndpoint"
+	registrationTemplate      = "Register%sServerAndHandler"
+	serviceDescTemplate       = "%s_ServiceDesc"
+	clientMethodTemplate      = "request_%s_%s_"
+	localMethodTemplate       = "local_request_%s_%s_"
+	fullMethodTemplate        = "/%s/%s"
+	grpcFileTemplate          = "%s_grpc.pb.go"
+	fullMethodNameTemplate    = "%s_%s_FullMethodName"
+	methodCatalogTemplate     = "%s_MethodCatalog"
+	authRulesTemplate         = "%s_AuthRules"
+
+	rateLimitPeerKey         = "peer"
+	rateLimitHeaderKeyPrefix = "header:"
+
+	outDirParam             = "outdir"
+	clientInterceptorsParam = "client_interceptors"
+	includeParam            = "include"
+	excludeParam            = "exclude"
+	recoverParam            = "recover"
+	validateParam           = "validate"
+	maxRequestBytesParam    = "max_request_bytes"
+	metricsParam            = "metrics"
+	tracingParam            = "tracing"
+	auditParam              = "audit"
+	templateDirParam        = "template_dir"
+	configParam             = "config"
+
+	errType    = "error"
+	stringType = "string"
+
+	unaryServerInterceptorSelector  = "UnaryServerInterceptor"
+	serverMetadataSelector          = "ServerMetadata"
+	messageSelector                 = "Message"
+	contextSelector                 = "Context"
+	optionSelector                  = "Option"
+	optionsSelector                 = "Options"
+	newOptionsSelector              = "NewOptions"
+	invokeUnaryClientSelector       = "InvokeUnaryClient"
+	interceptUnaryRequestSelector   = "InterceptUnaryRequest"
+	checkNamedInterceptorsSelector  = "CheckNamedInterceptors"
+	checkMethodInterceptorsSelector = "CheckMethodInterceptors"
+	dialOptionsSelector             = "DialOptions"
+	dialSelector                    = "Dial"
+	appendFunc                      = "append"
+	registerServiceSelector         = "RegisterService"
+	serviceRegistrarSelector        = "ServiceRegistrar"
+	serveMuxSelector                = "ServeMux"
+	validateRequestSelector         = "ValidateRequest"
+	auditRequestSelector            = "AuditRequest"
+	withAuthRulesSelector           = "WithAuthRules"
+	withMethodCatalogSelector       = "WithMethodCatalog"
+	withMetricsSelector             = "WithMetrics"
+	withTracingSelector             = "WithTracing"
+	withRecoverSelector             = "WithRecover"
+	withClientConnSelector          = "WithClientConn"
+	dialedInterceptorsSelector      = "WithDialedClientInterceptors"
+	setRetryAfterHeaderSelector     = "SetRetryAfterHeader"
+	httpErrorSelector               = "HTTPError"
+
+	interceptorVar      = "interceptor"
+	mdVar               = "md"
+	respVar             = "resp"
+	reqVar              = "req"
+	errVar              = "err"
+	ctxVar              = "ctx"
+	annotatedContextVar = "annotatedContext"
+	inboundMarshalerVar = "inboundMarshaler"
+	nilVar              = "nil"
+	serverVar           = "server"
+	pathParamsVar       = "pathParams"
+	optsVar             = "opts"
+	optionsVar          = "options"
+	clientVar           = "client"
+	connVar             = "conn"
+	pgiOptsVar          = "pgiOpts"
+	registrarVar        = "s"
+	muxVar              = "mux"
+	msgVar              = "msg"
+	protoReqVar         = "protoReq"
+	metadataVar         = "metadata"
+	writerVar           = "w"
+
+	protoPackage   = "proto"
+	runtimePackage = "runtime"
+	grpcPackage    = "grpc"
+	contextPackage = "context"
+	fmtPackage     = "fmt"
+	timePackage    = "time"
+
+	pgiRuntimePackage    = "pgiruntime"
+	pgiRuntimeImportPath = "github.com/tarmalonchik/protoc-gen-interceptors/runtime"
+	otelPGIPackage       = "otelpgi"
+	otelPGIImportPath    = pgiRuntimeImportPath + "/" + otelPGIPackage
+)
+
+type assignmentWithRPCMethodName struct {
+	fullMethod       string
+	interceptorNames []string
+	assignStmt       *ast.AssignStmt
+	funcName         string
+}
+
+type protoService struct {
+	serviceName          string
+	pkg                  string
+	registerFunctionName string
+	methods              []*descriptorpb.MethodDescriptorProto
+	interceptorNames     []string
+}
+
+type protoFile struct {
+	filename string
+	pkg      string
+	services []*descriptorpb.ServiceDescriptorProto
+	// messages of the file and its dependencies by full names with the leading dot
+	messages map[string]*descriptorpb.DescriptorProto
+}
+
+// isIntercepted checks full gRPC method name against include and exclude glob patterns of its service.
+// Patterns are matched with path.Match against the name without the leading slash.
+func (p Options) isIntercepted(fullMethod string) bool {
+	p = p.forMethod(fullMethod)
+	if p.direct {
+		return false
+	}
+	name := strings.TrimPrefix(fullMethod, "/")
+	if len(p.Include) != 0 && !matchAnyPattern(p.Include, name) {
+		return false
+	}
+	return !matchAnyPattern(p.Exclude, name)
+}
+
+// filterInterceptedMethods returns a copy of the file without the methods excluded from interception.
+func (p Options) filterInterceptedMethods(in protoFile) protoFile {
+	if len(p.Include) == 0 && len(p.Exclude) == 0 && p.Config == nil {
+		return in
+	}
+	resp := in
+	resp.services = make([]*descriptorpb.ServiceDescriptorProto, len(in.services))
+	for i, service := range in.services {
+		resp.services[i] = &descriptorpb.ServiceDescriptorProto{
+			Name:    service.Name,
+			Options: service.Options,
+		}
+		for _, method := range service.GetMethod() {
+			if p.isIntercepted(resolveFullMethodName(in.pkg, service.GetName(), method.GetName())) {
+				resp.services[i].Method = append(resp.services[i].Method, method)
+			}
+		}
+	}
+	return resp
+}
+
+func matchAnyPattern(patterns []string, name string) bool {
+	for i := range patterns {
+		// patterns are validated by ParseOptions and LoadConfig
+		if ok, _ := path.Match(patterns[i], name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getMethodsMap returns full gRPC method names by the names of local request functions.
+func getMethodsMap(in map[string]protoService) map[string]string {
+	resp := make(map[string]string)
+	for i := range in {
+		for j := range in[i].methods {
+			resp[fmt.Sprintf(methodFunctionTemplate, in[i].serviceName, in[i].methods[j].GetName())] = resolveFullMethodName(
+				in[i].pkg,
+				in[i].serviceName,
+				in[i].methods[j].GetName(),
+			)
+		}
+	}
+	return resp
+}
+
+// getMethodServerTypes returns the server types of the root functions by full gRPC method names.
+func getMethodServerTypes(in map[string]protoService, serverTypes map[string]string) map[string]string {
+	resp := make(map[string]string)
+	for rootFunctionName, service := range in {
+		for _, method := range service.methods {
+			resp[resolveFullMethodName(service.pkg, service.serviceName, method.GetName())] = serverTypes[rootFunctionName]
+		}
+	}
+	return resp
+}
+
+// getInterceptorNamesMap returns names of interceptors selected with pgi options by full gRPC method names.
+// Interceptors of the service go before the ones of the method.
+func getInterceptorNamesMap(pkg string, services []*descriptorpb.ServiceDescriptorProto) map[string][]string {
+	resp := make(map[string][]string)
+	for _, service := range services {
+		var serviceNames []string
+		if ext, ok := proto.GetExtension(service.GetOptions(), pgioptions.E_ServiceInterceptors).(*pgioptions.Interceptors); ok {
+			serviceNames = ext.GetNames()
+		}
+		for _, method := range service.GetMethod() {
+			names := append([]string{}, serviceNames...)
+			if ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_Interceptors).(*pgioptions.Interceptors); ok {
+				names = append(names, ext.GetNames()...)
+			}
+			if len(names) != 0 {
+				resp[resolveFullMethodName(pkg, service.GetName(), method.GetName())] = uniqueStrings(names)
+			}
+		}
+	}
+	return resp
+}
+
+type authRule struct {
+	method string
+	scopes []string
+	roles  []string
+}
+
+// getAuthRules returns the rules declared with the (pgi.auth) option by service names.
+// The rules are enforced by interceptors, so they are rejected for streaming and excluded methods.
+func getAuthRules(in protoFile, params Options) (map[string][]authRule, error) {
+	resp := make(map[string][]authRule)
+	for _, service := range in.services {
+		for _, method := range service.GetMethod() {
+			ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_Auth).(*pgioptions.Auth)
+			if !ok || ext == nil {
+				continue
+			}
+			fullMethod := resolveFullMethodName(in.pkg, service.GetName(), method.GetName())
+			if method.GetClientStreaming() || method.GetServerStreaming() {
+				return nil, fmt.Errorf("authorization rule of streaming method %s is not supported", fullMethod)
+			}
+			if !params.isIntercepted(fullMethod) {
+				return nil, fmt.Errorf("method %s has authorization rule but is excluded from interception", fullMethod)
+			}
+			resp[service.GetName()] = append(resp[service.GetName()], authRule{
+				method: method.GetName(),
+				scopes: ext.GetScopes(),
+				roles:  ext.GetRoles(),
+			})
+		}
+	}
+	return resp, nil
+}
+
+// methodSettings holds the per-method settings declared with pgi options, they are listed in the method catalog.
+type methodSettings struct {
+	timeout         time.Duration
+	maxRequestBytes int64
+	redactedFields  []string
+	rateLimit       *pgioptions.RateLimit
+	idempotencyKey  bool
+}
+
+// getMethodSettings returns the settings of the methods which declare any by full gRPC method names.
+// The defaults from plugin parameters apply to the methods which do not declare the setting.
+func getMethodSettings(in protoFile, params Options) (map[string]methodSettings, error) {
+	resp := make(map[string]methodSettings)
+	for _, service := range in.services {
+		serviceParams := params.forService(in.pkg, service.GetName())
+		for _, method := range service.GetMethod() {
+			var (
+				settings methodSettings
+				err      error
+			)
+			if settings.timeout, err = getTimeout(in.pkg, service.GetName(), method, params); err != nil {
+				return nil, err
+			}
+			if settings.maxRequestBytes, err = getMaxRequestBytes(in.pkg, service.GetName(), method, params); err != nil {
+				return nil, err
+			}
+			// the default limit is enforced by the interceptors as well, so it is left out of the catalog
+			// of the streaming and excluded methods instead of pretending they are limited
+			if settings.maxRequestBytes == 0 && !method.GetClientStreaming() && !method.GetServerStreaming() &&
+				params.isIntercepted(resolveFullMethodName(in.pkg, service.GetName(), method.GetName())) {
+				settings.maxRequestBytes = serviceParams.MaxRequestBytes
+			}
+			if serviceParams.Audit {
+				settings.redactedFields = getRedactedFields(in.messages, method.GetInputType())
+			}
+			if settings.rateLimit, err = getRateLimit(in.pkg, service.GetName(), method, params); err != nil {
+				return nil, err
+			}
+			if settings.idempotencyKey, err = getIdempotencyKey(in.pkg, service.GetName(), method, params); err != nil {
+				return nil, err
+			}
+			if settings.timeout != 0 || settings.maxRequestBytes != 0 || len(settings.redactedFields) != 0 ||
+				settings.rateLimit != nil || settings.idempotencyKey {
+				resp[resolveFullMethodName(in.pkg, service.GetName(), method.GetName())] = settings
+			}
+		}
+	}
+	return resp, nil
+}
+
+// getTimeout returns the timeout declared with the (pgi.timeout) option, the deadline is set by an interceptor,
+// so the option is rejected for streaming and excluded methods.
+func getTimeout(pkg, service string, method *descriptorpb.MethodDescriptorProto, params Options) (time.Duration, error) {
+	ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_Timeout).(*durationpb.Duration)
+	if !ok || ext == nil {
+		return 0, nil
+	}
+	fullMethod := resolveFullMethodName(pkg, service, method.GetName())
+	if method.GetClientStreaming() || method.GetServerStreaming() {
+		return 0, fmt.Errorf("timeout of streaming method %s is not supported", fullMethod)
+	}
+	if !params.isIntercepted(fullMethod) {
+		return 0, fmt.Errorf("method %s has timeout but is excluded from interception", fullMethod)
+	}
+	return ext.AsDuration(), nil
+}
+
+// getMaxRequestBytes returns the limit declared with the (pgi.max_request_bytes) option. The limit is checked
+// by an interceptor as well, so the option is rejected for streaming and excluded methods.
+func getMaxRequestBytes(pkg, service string, method *descriptorpb.MethodDescriptorProto, params Options) (int64, error) {
+	ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_MaxRequestBytes).(uint64)
+	if !ok || ext == 0 {
+		return 0, nil
+	}
+	fullMethod := resolveFullMethodName(pkg, service, method.GetName())
+	if method.GetClientStreaming() || method.GetServerStreaming() {
+		return 0, fmt.Errorf("request body limit of streaming method %s is not supported", fullMethod)
+	}
+	if !params.isIntercepted(fullMethod) {
+		return 0, fmt.Errorf("method %s has request body limit but is excluded from interception", fullMethod)
+	}
+	if ext > math.MaxInt64 {
+		ext = math.MaxInt64
+	}
+	return int64(ext), nil
+}
+
+// getRateLimit returns the rate limit declared with the (pgi.rate_limit) option, it is enforced by an interceptor
+// as the authorization rules, so it is rejected for streaming and excluded methods.
+func getRateLimit(pkg, service string, method *descriptorpb.MethodDescriptorProto, params Options) (*pgioptions.RateLimit, error) {
+	ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_RateLimit).(*pgioptions.RateLimit)
+	if !ok || ext == nil {
+		return nil, nil
+	}
+	fullMethod := resolveFullMethodName(pkg, service, method.GetName())
+	if method.GetClientStreaming() || method.GetServerStreaming() {
+		return nil, fmt.Errorf("rate limit of streaming method %s is not supported", fullMethod)
+	}
+	if !params.isIntercepted(fullMethod) {
+		return nil, fmt.Errorf("method %s has rate limit but is excluded from interception", fullMethod)
+	}
+	if !(ext.GetRps() > 0) || math.IsInf(ext.GetRps(), 1) {
+		return nil, fmt.Errorf("invalid rps %v in rate limit of method %s", ext.GetRps(), fullMethod)
+	}
+	if key := ext.GetKey(); key != "" && key != rateLimitPeerKey &&
+		(!strings.HasPrefix(key, rateLimitHeaderKeyPrefix) || key == rateLimitHeaderKeyPrefix) {
+		return nil, fmt.Errorf(
+			"invalid key %q in rate limit of method %s, expected %q or %q",
+			key, fullMethod, rateLimitPeerKey, rateLimitHeaderKeyPrefix+"<name>",
+		)
+	}
+	return ext, nil
+}
+
+// getRedactedFields returns the full names of the fields marked with (pgi.sensitive) or debug_redact
+// in the message and the messages it refers to. Fields of redacted fields are not listed.
+func getRedactedFields(messages map[string]*descriptorpb.DescriptorProto, typeName string) []string {
+	var resp []string
+	visited := make(map[string]interface{})
+	queue := []string{typeName}
+	for len(queue) != 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[name]; ok {
+			continue
+		}
+		visited[name] = nil
+		message, ok := messages[name]
+		if !ok {
+			continue
+		}
+		for _, field := range message.GetField() {
+			if isRedactedField(field) {
+				resp = append(resp, strings.TrimPrefix(name, ".")+"."+field.GetName())
+				continue
+			}
+			if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+				queue = append(queue, field.GetTypeName())
+			}
+		}
+	}
+	sort.Strings(resp)
+	return resp
+}
+
+// debugRedactFieldNumber is the number of debug_redact in google.protobuf.FieldOptions,
+// descriptorpb of the protobuf version in use does not declare it, so it is read from the unknown fields.
+const debugRedactFieldNumber = 16
+
+func isRedactedField(field *descriptorpb.FieldDescriptorProto) bool {
+	if sensitive, ok := proto.GetExtension(field.GetOptions(), pgioptions.E_Sensitive).(bool); ok && sensitive {
+		return true
+	}
+	var debugRedact bool
+	unknown := field.GetOptions().ProtoReflect().GetUnknown()
+	for len(unknown) != 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return false
+		}
+		unknown = unknown[n:]
+		if num == debugRedactFieldNumber && typ == protowire.VarintType {
+			val, n := protowire.ConsumeVarint(unknown)
+			if n < 0 {
+				return false
+			}
+			// the last value wins
+			debugRedact, unknown = val != 0, unknown[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, unknown); n < 0 {
+			return false
+		}
+		unknown = unknown[n:]
+	}
+	return debugRedact
+}
+
+// getIdempotencyKey reports whether the method is declared with the (pgi.idempotency_key) option.
+// Responses are replayed by an interceptor on the gateway, so it is rejected for streaming and excluded methods.
+func getIdempotencyKey(pkg, service string, method *descriptorpb.MethodDescriptorProto, params Options) (bool, error) {
+	if ext, ok := proto.GetExtension(method.GetOptions(), pgioptions.E_IdempotencyKey).(bool); !ok || !ext {
+		return false, nil
+	}
+	fullMethod := resolveFullMethodName(pkg, service, method.GetName())
+	if method.GetClientStreaming() || method.GetServerStreaming() {
+		return false, fmt.Errorf("idempotency key of streaming method %s is not supported", fullMethod)
+	}
+	if !params.isIntercepted(fullMethod) {
+		return false, fmt.Errorf("method %s has idempotency key but is excluded from interception", fullMethod)
+	}
+	return true, nil
+}
+
+// getGeneratedOptions returns the options which the generated code adds to the ones passed to registration
+// by service names: the authorization rules, the method catalog if the service declares per-method settings
+// and the tracing, the metrics and the panic recovery enabled with plugin parameters.
+func getGeneratedOptions(
+	in protoFile,
+	params Options,
+	authRules map[string][]authRule,
+	settings map[string]methodSettings,
+) map[string][]ast.Expr {
+	resp := make(map[string][]ast.Expr)
+	for _, service := range in.services {
+		serviceParams := params.forService(in.pkg, service.GetName())
+		if serviceParams.Tracing {
+			resp[service.GetName()] = append(resp[service.GetName()], getCallExpr(
+				getSelectorExpr(otelPGIPackage, withTracingSelector),
+			))
+		}
+		if serviceParams.Metrics {
+			resp[service.GetName()] = append(resp[service.GetName()], getCallExpr(
+				getSelectorExpr(pgiRuntimePackage, withMetricsSelector),
+				genIdent(serviceParams.methodCatalogName(service.GetName())),
+			))
+		}
+		if serviceParams.Recover {
+			resp[service.GetName()] = append(resp[service.GetName()], getCallExpr(
+				getSelectorExpr(pgiRuntimePackage, withRecoverSelector),
+			))
+		}
+		if len(authRules[service.GetName()]) != 0 {
+			resp[service.GetName()] = append(resp[service.GetName()], getCallExpr(
+				getSelectorExpr(pgiRuntimePackage, withAuthRulesSelector),
+				genIdent(serviceParams.authRulesName(service.GetName())),
+			))
+		}
+		for _, method := range service.GetMethod() {
+			if _, ok := settings[resolveFullMethodName(in.pkg, service.GetName(), method.GetName())]; ok {
+				resp[service.GetName()] = append(resp[service.GetName()], getCallExpr(
+					getSelectorExpr(pgiRuntimePackage, withMethodCatalogSelector),
+					genIdent(serviceParams.methodCatalogName(service.GetName())),
+				))
+				break
+			}
+		}
+	}
+	return resp
+}
+
+// generateOptsExpr returns the options passed to registration, the generated ones go first.
+func generateOptsExpr(optsName string, generated []ast.Expr) ast.Expr {
+	if len(generated) == 0 {
+		return genIdent(optsName)
+	}
+	appendCall := getCallExpr(
+		genIdent(appendFunc),
+		getCompositeLit(
+			&ast.ArrayType{Elt: getSelectorExpr(pgiRuntimePackage, optionSelector)},
+			generated...,
+		),
+		genIdent(optsName),
+	)
+	setEllipsis(appendCall)
+	return appendCall
+}
+
+// getServiceMethodsInterceptorNames returns names of interceptors selected with pgi options
+// by full gRPC method names of the service.
+func getServiceMethodsInterceptorNames(pkg string, service *descriptorpb.ServiceDescriptorProto, namesMap map[string][]string) map[string][]string {
+	resp := make(map[string][]string)
+	for _, method := range service.GetMethod() {
+		fullMethod := resolveFullMethodName(pkg, service.GetName(), method.GetName())
+		if names, ok := namesMap[fullMethod]; ok {
+			resp[fullMethod] = names
+		}
+	}
+	return resp
+}
+
+// getServiceInterceptorNames returns names of interceptors selected by any method of the service.
+func getServiceInterceptorNames(pkg string, service *descriptorpb.ServiceDescriptorProto, namesMap map[string][]string) []string {
+	var resp []string
+	for _, method := range service.GetMethod() {
+		resp = append(resp, namesMap[resolveFullMethodName(pkg, service.GetName(), method.GetName())]...)
+	}
+	return uniqueStrings(resp)
+}
+
+func uniqueStrings(in []string) []string {
+	var resp []string
+	seen := make(map[string]interface{})
+	for i := range in {
+		if _, ok := seen[in[i]]; ok {
+			continue
+		}
+		seen[in[i]] = nil
+		resp = append(resp, in[i])
+	}
+	return resp
+}
+
+// transform rewrites src of the file generated by grpc-gateway for singleFile.
+// Names declared in grpcSrc generated by protoc-gen-go-grpc are not generated again.
+func transform(src []byte, singleFile protoFile, grpcSrc []byte, params Options) ([]byte, error) {
+	var (
+		functions         = make(map[string]assignmentWithRPCMethodName)
+		documentedDecls   []*ast.FuncDecl
+		serverTypes       = make(map[string]string)
+		declaredFunctions = make(map[string]interface{})
+		declaredNames     map[string]interface{}
+	)
+
+	// methods excluded with plugin parameters keep calling local requests directly
+	interceptedFile := params.filterInterceptedMethods(singleFile)
+
+	rootFunctions := getRootFunctionsNames(interceptedFile)
+
+	currentFileMethods := getMethodsMap(rootFunctions)
+
+	interceptorNames := getInterceptorNamesMap(interceptedFile.pkg, interceptedFile.services)
+
+	// interceptors may be added for excluded methods as well, they still run on the gRPC server
+	unaryMethods := getUnaryMethodNames(singleFile)
+
+	authRules, err := getAuthRules(singleFile, params)
+	if err != nil {
+		return nil, fmt.Errorf("resolving authorization rules: %w", err)
+	}
+
+	settings, err := getMethodSettings(singleFile, params)
+	if err != nil {
+		return nil, fmt.Errorf("resolving method settings: %w", err)
+	}
+
+	generatedOpts := getGeneratedOptions(singleFile, params, authRules, settings)
+
+	fSet := token.NewFileSet()
+
+	fileAst, err := parser.ParseFile(
+		fSet,
+		fmt.Sprintf(generatedFileTemplate, resolveProtoFileName(singleFile.filename)),
+		src,
+		parser.ParseComments,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parsing go code: %w", err)
+	}
+
+	astutil.Apply(
+		fileAst,
+		nil,
+		func(cursor *astutil.Cursor) bool {
+			if funcDecl, ok := cursor.Node().(*ast.FuncDecl); ok {
+				if funcDecl.Name != nil {
+					declaredFunctions[funcDecl.Name.Name] = nil
+					// checking if the function is root
+					if _, ok = rootFunctions[funcDecl.Name.Name]; ok {
+						serverTypes[funcDecl.Name.Name] = resolveServerType(funcDecl)
+						if ok = checkIfFuncNeedField(funcDecl, interceptorVar); ok {
+							// adding new field to root function
+							funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, getInterceptorField())
+						}
+						if ok = checkIfFuncNeedField(funcDecl, optsVar); ok {
+							// adding options to root function, they are resolved once per registration
+							funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, getOptionsField(optsVar))
+							rootService := rootFunctions[funcDecl.Name.Name]
+							insertOptionsAssignment(funcDecl, generateOptsExpr(optsVar, generatedOpts[rootService.serviceName]), generateOptionsChecks(
+								resolveFullServiceName(rootService.pkg, rootService.serviceName),
+								unaryMethods[rootService.serviceName],
+								rootService.interceptorNames,
+							)...)
+						}
+					} else if _, ok = functions[funcDecl.Name.Name]; ok {
+						// we need to delete old functions generated by this package to add them again later
+						cursor.Delete()
+					}
+				}
+			}
+			if assignStmt, ok := cursor.Node().(*ast.AssignStmt); ok {
+				if len(assignStmt.Rhs) == 1 {
+					if callExpr, ok := assignStmt.Rhs[0].(*ast.CallExpr); ok {
+						if funcIdent, ok := callExpr.Fun.(*ast.Ident); ok {
+							newFunctionName := fmt.Sprintf(generatedFunctionTemplate, interceptorVar, funcIdent.Name)
+							// should replace old function call with new one which will be generated at the end of file
+							fullMethod, isCurrentFileMethod := currentFileMethods[funcIdent.Name]
+							generatedFunc, isNewGeneratedFunc := functions[newFunctionName]
+							if isNewGeneratedFunc {
+								fullMethod = generatedFunc.fullMethod
+							}
+							if isCurrentFileMethod || isNewGeneratedFunc {
+								if len(callExpr.Args) != 0 {
+									// handler should use the context passed by interceptor
+									callExpr.Args[0] = genIdent(ctxVar)
+								}
+								cursor.Replace(generateAssignmentStatement(newFunctionName))
+								functions[newFunctionName] = assignmentWithRPCMethodName{
+									fullMethod:       fullMethod,
+									interceptorNames: interceptorNames[fullMethod],
+									assignStmt:       assignStmt,
+									funcName:         newFunctionName,
+								}
+							}
+						}
+					}
+				}
+			}
+			return true
+		},
+	)
+
+	// client interceptors may be enabled for some services of the file only
+	clientFile := interceptedFile
+	clientFile.services = nil
+	for _, service := range interceptedFile.services {
+		if params.forService(interceptedFile.pkg, service.GetName()).ClientInterceptors {
+			clientFile.services = append(clientFile.services, service)
+		}
+	}
+	if len(clientFile.services) != 0 {
+		applyClientInterceptors(fileAst, clientFile, unaryMethods, generatedOpts)
+	}
+
+	applyDecodedRequestStmts(fileAst, singleFile, params)
+
+	applyRetryAfterStmts(fileAst, settings)
+
+	// adding functions to the end of the generated files, the calls are visited before their root functions,
+	// so the server types are resolved once all of them are known
+	wrappers, err := renderWrappers(fSet, functions, getMethodServerTypes(rootFunctions, serverTypes), params)
+	if err != nil {
+		return nil, fmt.Errorf("rendering wrappers: %w", err)
+	}
+
+	// adding helpers registering both gRPC server and gateway handlers
+	for _, service := range interceptedFile.services {
+		rootFunctionName := fmt.Sprintf(rootFunctionTemplate, service.GetName())
+		helperName := params.forService(interceptedFile.pkg, service.GetName()).registrationName(service.GetName())
+		if _, ok := serverTypes[rootFunctionName]; !ok {
+			continue
+		}
+		if _, ok := declaredFunctions[helperName]; ok {
+			continue
+		}
+		documentedDecls = append(documentedDecls, generateRegistrationFunction(
+			helperName,
+			service.GetName(),
+			serverTypes[rootFunctionName],
+			getServiceMethodsInterceptorNames(interceptedFile.pkg, service, interceptorNames),
+			generatedOpts[service.GetName()],
+		))
+	}
+
+	// the constants are generated by protoc-gen-go-grpc since v1.3, they are reused if present
+	if declaredNames, err = getDeclaredNames(fSet, fmt.Sprintf(grpcFileTemplate, resolveProtoFileName(singleFile.filename)), grpcSrc); err != nil {
+		return nil, fmt.Errorf("parsing go code of protoc-gen-go-grpc: %w", err)
+	}
+	for _, decl := range fileAst.Decls {
+		addDeclaredNames(declaredNames, decl)
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	if len(functions) != 0 {
+		// used by the generated wrappers only
+		astutil.AddImport(fSet, fileAst, fmtPackage)
+	}
+	astutil.AddNamedImport(fSet, fileAst, pgiRuntimePackage, pgiRuntimeImportPath)
+	for _, service := range singleFile.services {
+		if params.forService(singleFile.pkg, service.GetName()).Tracing {
+			// used by the generated options, so the files without tracing do not depend on OpenTelemetry
+			astutil.AddImport(fSet, fileAst, otelPGIImportPath)
+			break
+		}
+	}
+	for _, val := range settings {
+		if val.timeout != 0 {
+			// used by the method catalog
+			astutil.AddImport(fSet, fileAst, timePackage)
+			break
+		}
+	}
+
+	if err = printer.Fprint(buf, fSet, fileAst); err != nil {
+		return nil, fmt.Errorf("writing node to buffer: %w", err)
+	}
+	buf.Write(wrappers)
+
+	for _, decl := range documentedDecls {
+		if err = printDocumentedFunc(buf, fSet, decl); err != nil {
+			return nil, fmt.Errorf("writing node to buffer: %w", err)
+		}
+	}
+
+	writeMethodCatalog(buf, singleFile, params, settings, declaredNames)
+	writeAuthRules(buf, singleFile, params, authRules, declaredNames)
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return formatted, nil
+}
+
+// applyClientInterceptors makes the Register*HandlerClient path call gRPC client through
+// the client interceptors passed with options.
+// Interceptors added for methods are checked against unaryMethods given by service names.
+// The options generated for the services are given by service names in generatedOpts.
+func applyClientInterceptors(fileAst *ast.File, singleFile protoFile, unaryMethods map[string][]string, generatedOpts map[string][]ast.Expr) {
+	clientRoots := make(map[string]interface{})
+	connRoots := make(map[string]string)
+	endpointRoots := make(map[string]string)
+	clientMethods := make(map[string]string)
+	clientRootsChecks := make(map[string][]ast.Stmt)
+	clientRootsServices := make(map[string]string)
+	interceptorNames := getInterceptorNamesMap(singleFile.pkg, singleFile.services)
+
+	for _, service := range singleFile.services {
+		clientRoots[fmt.Sprintf(clientRootTemplate, service.GetName())] = nil
+		clientRootsServices[fmt.Sprintf(clientRootTemplate, service.GetName())] = service.GetName()
+		clientRootsChecks[fmt.Sprintf(clientRootTemplate, service.GetName())] = generateOptionsChecks(
+			resolveFullServiceName(singleFile.pkg, service.GetName()),
+			unaryMethods[service.GetName()],
+			getServiceInterceptorNames(singleFile.pkg, service, interceptorNames),
+		)
+		connRoots[fmt.Sprintf(connRootTemplate, service.GetName())] = fmt.Sprintf(clientRootTemplate, service.GetName())
+		endpointRoots[fmt.Sprintf(endpointRootTemplate, service.GetName())] = fmt.Sprintf(connRootTemplate, service.GetName())
+		for _, method := range service.GetMethod() {
+			if method.GetClientStreaming() || method.GetServerStreaming() {
+				continue
+			}
+			clientMethods[fmt.Sprintf(clientMethodTemplate, service.GetName(), method.GetName())] = resolveFullMethodName(singleFile.pkg, service.GetName(), method.GetName())
+		}
+	}
+
+	astutil.Apply(
+		fileAst,
+		nil,
+		func(cursor *astutil.Cursor) bool {
+			if funcDecl, ok := cursor.Node().(*ast.FuncDecl); ok && funcDecl.Name != nil {
+				if _, ok = clientRoots[funcDecl.Name.Name]; ok {
+					if ok = checkIfFuncNeedField(funcDecl, optsVar); ok {
+						funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, getOptionsField(optsVar))
+						insertOptionsAssignment(
+							funcDecl,
+							generateOptsExpr(optsVar, generatedOpts[clientRootsServices[funcDecl.Name.Name]]),
+							clientRootsChecks[funcDecl.Name.Name]...,
+						)
+					}
+				} else if clientRoot, ok := connRoots[funcDecl.Name.Name]; ok {
+					if ok = checkIfFuncNeedField(funcDecl, optsVar); ok {
+						funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, getOptionsField(optsVar))
+						// client interceptors get the connection the client is created for
+						passOptionsToCall(funcDecl, clientRoot, optsVar, getCallExpr(
+							getSelectorExpr(pgiRuntimePackage, withClientConnSelector),
+							genIdent(connVar),
+						))
+					}
+				} else if connRoot, ok := endpointRoots[funcDecl.Name.Name]; ok {
+					// opts are already used for dial options there
+					if ok = checkIfFuncNeedField(funcDecl, pgiOptsVar); ok {
+						funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, getOptionsField(pgiOptsVar))
+						// client interceptors are installed on the dialed connection
+						passOptionsToCall(funcDecl, connRoot, pgiOptsVar, getCallExpr(
+							getSelectorExpr(pgiRuntimePackage, dialedInterceptorsSelector),
+						))
+						appendDialOptions(funcDecl)
+						insertOptionsAssignment(funcDecl, genIdent(pgiOptsVar))
+					}
+				} else if fullMethod, ok := clientMethods[resolveClientMethodPrefix(funcDecl.Name.Name)]; ok {
+					if ok = checkIfFuncNeedField(funcDecl, optionsVar); ok {
+						funcDecl.Type.Params.List = append(funcDecl.Type.Params.List, generateField(true, pgiRuntimePackage, optionsSelector, optionsVar))
+						wrapClientCall(funcDecl, fullMethod)
+					}
+				}
+			}
+			if callExpr, ok := cursor.Node().(*ast.CallExpr); ok {
+				if funcIdent, ok := callExpr.Fun.(*ast.Ident); ok {
+					// request functions got options as the last parameter
+					if fullMethod, ok := clientMethods[resolveClientMethodPrefix(funcIdent.Name)]; ok && len(callExpr.Args) == 5 {
+						callExpr.Args = append(callExpr.Args, genIdent(optionsVar))
+						cursor.Replace(generateInterceptUnaryRequestCall(callExpr, fullMethod, interceptorNames[fullMethod]))
+					}
+				}
+			}
+			return true
+		},
+	)
+}
+
+// applyDecodedRequestStmts makes local_request_* and request_* functions of unary methods pass
+// the decoded request to the audit record and validate it before it is sent to the server or the client.
+func applyDecodedRequestStmts(fileAst *ast.File, singleFile protoFile, params Options) {
+	methodPrefixes := make(map[string]Options)
+	for _, service := range singleFile.services {
+		serviceParams := params.forService(singleFile.pkg, service.GetName())
+		if !serviceParams.Audit && !serviceParams.Validate {
+			continue
+		}
+		for _, method := range service.GetMethod() {
+			if method.GetClientStreaming() || method.GetServerStreaming() {
+				continue
+			}
+			methodPrefixes[fmt.Sprintf(localMethodTemplate, service.GetName(), method.GetName())] = serviceParams
+			methodPrefixes[fmt.Sprintf(clientMethodTemplate, service.GetName(), method.GetName())] = serviceParams
+		}
+	}
+
+	for _, decl := range fileAst.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Name == nil || funcDecl.Body == nil {
+			continue
+		}
+		serviceParams, ok := methodPrefixes[resolveClientMethodPrefix(funcDecl.Name.Name)]
+		if !ok {
+			continue
+		}
+		for i, stmt := range funcDecl.Body.List {
+			// the request is sent with "msg, err := ..." once it is decoded
+			if assignStmt, ok := stmt.(*ast.AssignStmt); ok && len(assignStmt.Lhs) == 2 {
+				if ident, ok := assignStmt.Lhs[0].(*ast.Ident); ok && ident.Name == msgVar {
+					var stmts []ast.Stmt
+					// the statements are present already when the file is transformed again
+					if serviceParams.Audit && !checkIfSelectorCalled(funcDecl.Body, pgiRuntimePackage, auditRequestSelector) {
+						// invalid requests are audited as well
+						stmts = append(stmts, generateAuditStmt(assignStmt.Pos()))
+					}
+					if serviceParams.Validate && !checkIfSelectorCalled(funcDecl.Body, pgiRuntimePackage, validateRequestSelector) {
+						stmts = append(stmts, generateValidationStmt(assignStmt.Pos()))
+					}
+					funcDecl.Body.List = append(funcDecl.Body.List[:i], append(stmts, funcDecl.Body.List[i:]...)...)
+					break
+				}
+			}
+		}
+	}
+}
+
+// applyRetryAfterStmts makes the HTTP handlers of the rate limited methods set the Retry-After header
+// from the error, the metadata set by the interceptor reaches the response as Grpc-Metadata-Retry-After only.
+func applyRetryAfterStmts(fileAst *ast.File, settings map[string]methodSettings) {
+	rateLimited := make(map[string]interface{})
+	for fullMethod, val := range settings {
+		if val.rateLimit != nil {
+			rateLimited[strconv.Quote(fullMethod)] = nil
+		}
+	}
+	if len(rateLimited) == 0 {
+		return
+	}
+
+	ast.Inspect(fileAst, func(node ast.Node) bool {
+		funcLit, ok := node.(*ast.FuncLit)
+		if !ok || !checkIfLitUsed(funcLit.Body, rateLimited) {
+			return true
+		}
+		for _, stmt := range funcLit.Body.List {
+			// the call errors are written with "runtime.HTTPError(annotatedContext, ...)"
+			ifStmt, ok := stmt.(*ast.IfStmt)
+			if !ok || len(ifStmt.Body.List) == 0 || !checkIfHTTPErrorStmt(ifStmt.Body.List[0]) {
+				continue
+			}
+			if checkIfSelectorCalled(ifStmt.Body, pgiRuntimePackage, setRetryAfterHeaderSelector) {
+				continue
+			}
+			setRetryAfterStmt := &ast.ExprStmt{X: getCallExpr(
+				getSelectorExpr(pgiRuntimePackage, setRetryAfterHeaderSelector),
+				genIdent(writerVar),
+				genIdent(errVar),
+			)}
+			setNodePos(setRetryAfterStmt, ifStmt.Body.List[0].Pos())
+			ifStmt.Body.List = append(stmtToList(setRetryAfterStmt), ifStmt.Body.List...)
+		}
+		return false
+	})
+}
+
+func checkIfHTTPErrorStmt(stmt ast.Stmt) bool {
+	exprStmt, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	callExpr, ok := exprStmt.X.(*ast.CallExpr)
+	if !ok || len(callExpr.Args) == 0 || !checkIfSelectorCalled(callExpr, runtimePackage, httpErrorSelector) {
+		return false
+	}
+	ident, ok := callExpr.Args[0].(*ast.Ident)
+	return ok && ident.Name == annotatedContextVar
+}
+
+func checkIfLitUsed(node ast.Node, values map[string]interface{}) (resp bool) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		if basicLit, ok := node.(*ast.BasicLit); ok && basicLit.Kind == token.STRING {
+			_, resp = values[basicLit.Value]
+		}
+		return !resp
+	})
+	return resp
+}
+
+// The statement is positioned at pos, so the printer keeps the comments which follow in place.
+func generateValidationStmt(pos token.Pos) *ast.IfStmt {
+	ifStmt := getIfStmt(
+		getBinaryExpr(token.NEQ, errVar, nilVar),
+		&ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: exprToList(genIdentWithObj(errVar, ast.Var)),
+			Rhs: exprToList(
+				getCallExpr(
+					getSelectorExpr(pgiRuntimePackage, validateRequestSelector),
+					getUnaryExpr(token.AND, genIdent(protoReqVar)),
+				),
+			),
+		},
+		nil,
+		stmtToList(getReturnStmt(genIdent(nilVar), genIdent(metadataVar), genIdent(errVar))),
+	)
+	ifStmt.If, ifStmt.Body.Lbrace, ifStmt.Body.Rbrace = pos, pos, pos
+	return ifStmt
+}
+
+// generateAuditStmt is positioned at pos for the same reason as generateValidationStmt.
+func generateAuditStmt(pos token.Pos) *ast.ExprStmt {
+	selectorExpr := getSelectorExpr(pgiRuntimePackage, auditRequestSelector)
+	selectorExpr.X.(*ast.Ident).NamePos = pos
+	callExpr := getCallExpr(selectorExpr, genIdent(ctxVar), getUnaryExpr(token.AND, genIdent(protoReqVar)))
+	callExpr.Rparen = pos
+	return &ast.ExprStmt{X: callExpr}
+}
+
+// resolveClientMethodPrefix cuts the binding index from request_<Service>_<Method>_<N> and
+// local_request_<Service>_<Method>_<N> function names.
+func resolveClientMethodPrefix(funcName string) string {
+	idx := strings.LastIndex(funcName, "_")
+	if idx == -1 {
+		return ""
+	}
+	if _, err := strconv.Atoi(funcName[idx+1:]); err != nil {
+		return ""
+	}
+	return funcName[:idx+1]
+}
+
+func resolveFullMethodName(pkg, service, method string) string {
+	return fmt.Sprintf(fullMethodTemplate, resolveFullServiceName(pkg, service), method)
+}
+
+func resolveFullServiceName(pkg, service string) string {
+	if pkg != "" {
+		return pkg + "." + service
+	}
+	return service
+}
+
+// getUnaryMethodNames returns names of unary methods by service names.
+func getUnaryMethodNames(in protoFile) map[string][]string {
+	resp := make(map[string][]string)
+	for _, service := range in.services {
+		for _, method := range service.GetMethod() {
+			if method.GetClientStreaming() || method.GetServerStreaming() {
+				continue
+			}
+			resp[service.GetName()] = append(resp[service.GetName()], method.GetName())
+		}
+	}
+	return resp
+}
+
+// passOptionsToCall adds optsName... to the call of funcName inside funcDecl.
+func passOptionsToCall(funcDecl *ast.FuncDecl, funcName, optsName string, generated ...ast.Expr) {
+	ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+		if callExpr, ok := node.(*ast.CallExpr); ok {
+			if funcIdent, ok := callExpr.Fun.(*ast.Ident); ok && funcIdent.Name == funcName && !callExpr.Ellipsis.IsValid() {
+				optsExpr := generateOptsExpr(optsName, generated)
+				setNodePos(optsExpr, callExpr.Rparen)
+				callExpr.Args = append(callExpr.Args, optsExpr)
+				setEllipsis(callExpr)
+			}
+		}
+		return true
+	})
+}
+
+// setNodePos places the generated node at pos of the call it is added to,
+// so the printer does not move the comments of the following declarations into it.
+func setNodePos(node ast.Node, pos token.Pos) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Ident:
+			node.NamePos = pos
+		case *ast.CallExpr:
+			node.Lparen, node.Rparen = pos, pos
+			if node.Ellipsis.IsValid() {
+				node.Ellipsis = pos
+			}
+		case *ast.CompositeLit:
+			node.Lbrace, node.Rbrace = pos, pos
+		case *ast.ArrayType:
+			node.Lbrack = pos
+		}
+		return true
+	})
+}
+
+// appendDialOptions adds dial options from pgi options to grpc.Dial call inside funcDecl.
+func appendDialOptions(funcDecl *ast.FuncDecl) {
+	ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+		callExpr, ok := node.(*ast.CallExpr)
+		if !ok || len(callExpr.Args) == 0 || !callExpr.Ellipsis.IsValid() {
+			return true
+		}
+		selectorExpr, ok := callExpr.Fun.(*ast.SelectorExpr)
+		if !ok || selectorExpr.Sel.Name != dialSelector {
+			return true
+		}
+		if ident, ok := selectorExpr.X.(*ast.Ident); !ok || ident.Name != grpcPackage {
+			return true
+		}
+		appendCall := getCallExpr(
+			genIdent(appendFunc),
+			callExpr.Args[len(callExpr.Args)-1],
+			getCallExpr(getSelectorExpr(optionsVar, dialOptionsSelector)),
+		)
+		setEllipsis(appendCall)
+		callExpr.Args[len(callExpr.Args)-1] = appendCall
+		return false
+	})
+}
+
+// generateInterceptUnaryRequestCall wraps the call of request function, so the unary server
+// interceptors from options are executed before the request is dispatched to gRPC client.
+func generateInterceptUnaryRequestCall(callExpr *ast.CallExpr, fullMethod string, interceptorNames []string) *ast.CallExpr {
+	ctxArg, reqArg, pathParamsArg := callExpr.Args[0], callExpr.Args[3], callExpr.Args[4]
+	callExpr.Args[0] = genIdent(ctxVar)
+
+	interceptCall := getCallExpr(
+		getSelectorExpr(pgiRuntimePackage, interceptUnaryRequestSelector),
+		ctxArg,
+		genIdent(optionsVar),
+		getBasicLit(token.STRING, strconv.Quote(fullMethod)),
+		reqArg,
+		pathParamsArg,
+		&ast.FuncLit{
+			Type: &ast.FuncType{
+				Params: fieldsToList(generateField(false, contextPackage, contextSelector, ctxVar)),
+				Results: fieldsToList(
+					generateField(false, protoPackage, messageSelector),
+					generateField(false, runtimePackage, serverMetadataSelector),
+					generateField(false, "", errType),
+				),
+			},
+			Body: getBlockStmnt(getReturnStmt(callExpr)),
+		},
+	)
+	interceptCall.Args = append(interceptCall.Args, stringsToBasicLits(interceptorNames)...)
+	return interceptCall
+}
+
+// wrapClientCall replaces client.Method(ctx, &protoReq, opts...) with the call made through client interceptors.
+func wrapClientCall(funcDecl *ast.FuncDecl, fullMethod string) {
+	ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+		callExpr, ok := node.(*ast.CallExpr)
+		if !ok || len(callExpr.Args) < 2 {
+			return true
+		}
+		selectorExpr, ok := callExpr.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := selectorExpr.X.(*ast.Ident); !ok || ident.Name != clientVar {
+			return true
+		}
+		args := exprToList(
+			callExpr.Args[0],
+			genIdent(optionsVar),
+			getBasicLit(token.STRING, strconv.Quote(fullMethod)),
+			callExpr.Args[1],
+			selectorExpr,
+		)
+		callExpr.Fun = getSelectorExpr(pgiRuntimePackage, invokeUnaryClientSelector)
+		callExpr.Args = append(args, callExpr.Args[2:]...)
+		return false
+	})
+}
+
+// getDeclaredNames returns names declared at the top level of src, src is nil when the file does not exist.
+func getDeclaredNames(fSet *token.FileSet, fileName string, src []byte) (map[string]interface{}, error) {
+	resp := make(map[string]interface{})
+	if src == nil {
+		return resp, nil
+	}
+	fileAst, err := parser.ParseFile(fSet, fileName, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	for _, decl := range fileAst.Decls {
+		addDeclaredNames(resp, decl)
+	}
+	return resp, nil
+}
+
+func addDeclaredNames(names map[string]interface{}, decl ast.Decl) {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil {
+			names[decl.Name.Name] = nil
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					names[name.Name] = nil
+				}
+			case *ast.TypeSpec:
+				names[spec.Name.Name] = nil
+			}
+		}
+	}
+}
+
+type httpBinding struct {
+	verb    string
+	pattern string
+}
+
+// getHTTPBindings returns the google.api.http bindings of the method including the additional ones.
+func getHTTPBindings(method *descriptorpb.MethodDescriptorProto) []httpBinding {
+	rule, ok := proto.GetExtension(method.GetOptions(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil
+	}
+	var resp []httpBinding
+	for _, item := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		switch pattern := item.GetPattern().(type) {
+		case *annotations.HttpRule_Get:
+			resp = append(resp, httpBinding{verb: http.MethodGet, pattern: pattern.Get})
+		case *annotations.HttpRule_Put:
+			resp = append(resp, httpBinding{verb: http.MethodPut, pattern: pattern.Put})
+		case *annotations.HttpRule_Post:
+			resp = append(resp, httpBinding{verb: http.MethodPost, pattern: pattern.Post})
+		case *annotations.HttpRule_Delete:
+			resp = append(resp, httpBinding{verb: http.MethodDelete, pattern: pattern.Delete})
+		case *annotations.HttpRule_Patch:
+			resp = append(resp, httpBinding{verb: http.MethodPatch, pattern: pattern.Patch})
+		case *annotations.HttpRule_Custom:
+			resp = append(resp, httpBinding{verb: pattern.Custom.GetKind(), pattern: pattern.Custom.GetPath()})
+		}
+	}
+	return resp
+}
+
+// writeMethodCatalog writes full method name constants and the table of HTTP bindings for every service.
+// The catalog is written as text to keep one entry per line, names from declared are not written again.
+func writeMethodCatalog(buf *bytes.Buffer, file protoFile, params Options, settings map[string]methodSettings, declared map[string]interface{}) {
+	for _, service := range file.services {
+		var constants []string
+		for _, method := range service.GetMethod() {
+			constName := fmt.Sprintf(fullMethodNameTemplate, service.GetName(), method.GetName())
+			if _, ok := declared[constName]; !ok {
+				constants = append(constants, fmt.Sprintf(
+					"\t%s = %q\n", constName, resolveFullMethodName(file.pkg, service.GetName(), method.GetName()),
+				))
+			}
+		}
+		if len(constants) != 0 {
+			fmt.Fprintf(buf, "\n// Full method names of service %s.\nconst (\n%s)\n", service.GetName(), strings.Join(constants, ""))
+		}
+
+		catalogName := params.forService(file.pkg, service.GetName()).methodCatalogName(service.GetName())
+		if _, ok := declared[catalogName]; ok {
+			continue
+		}
+		fmt.Fprintf(buf, "\n// %s lists the methods of service %s with their HTTP bindings.\n", catalogName, service.GetName())
+		fmt.Fprintf(buf, "var %s = []%s.MethodInfo{\n", catalogName, pgiRuntimePackage)
+		for _, method := range service.GetMethod() {
+			bindings := getHTTPBindings(method)
+			if len(bindings) == 0 {
+				bindings = []httpBinding{{}}
+			}
+			methodSettings := settings[resolveFullMethodName(file.pkg, service.GetName(), method.GetName())]
+			for _, binding := range bindings {
+				fields := []string{
+					"FullMethod: " + fmt.Sprintf(fullMethodNameTemplate, service.GetName(), method.GetName()),
+					"HTTPVerb: " + strconv.Quote(binding.verb),
+					"Pattern: " + strconv.Quote(binding.pattern),
+					"RequestType: " + strconv.Quote(strings.TrimPrefix(method.GetInputType(), ".")),
+					"ResponseType: " + strconv.Quote(strings.TrimPrefix(method.GetOutputType(), ".")),
+				}
+				if methodSettings.timeout != 0 {
+					fields = append(fields, "Timeout: "+formatDuration(methodSettings.timeout))
+				}
+				if methodSettings.maxRequestBytes != 0 {
+					fields = append(fields, "MaxRequestBytes: "+strconv.FormatInt(methodSettings.maxRequestBytes, 10))
+				}
+				if len(methodSettings.redactedFields) != 0 {
+					fields = append(fields, "RedactedFields: "+formatStringSlice(methodSettings.redactedFields))
+				}
+				if methodSettings.rateLimit != nil {
+					fields = append(fields, "RateLimit: "+formatRateLimit(methodSettings.rateLimit))
+				}
+				if methodSettings.idempotencyKey {
+					fields = append(fields, "IdempotencyKey: true")
+				}
+				fmt.Fprintf(buf, "\t{%s},\n", strings.Join(fields, ", "))
+			}
+		}
+		buf.WriteString("}\n")
+	}
+}
+
+// writeAuthRules writes the table of authorization rules for every service which has them.
+func writeAuthRules(buf *bytes.Buffer, file protoFile, params Options, authRules map[string][]authRule, declared map[string]interface{}) {
+	for _, service := range file.services {
+		rulesName := params.forService(file.pkg, service.GetName()).authRulesName(service.GetName())
+		if _, ok := declared[rulesName]; ok || len(authRules[service.GetName()]) == 0 {
+			continue
+		}
+		fmt.Fprintf(buf, "\n// %s lists the authorization rules declared for service %s.\n", rulesName, service.GetName())
+		fmt.Fprintf(buf, "var %s = []%s.AuthRule{\n", rulesName, pgiRuntimePackage)
+		for _, rule := range authRules[service.GetName()] {
+			fields := []string{"FullMethod: " + fmt.Sprintf(fullMethodNameTemplate, service.GetName(), rule.method)}
+			if len(rule.scopes) != 0 {
+				fields = append(fields, "Scopes: "+formatStringSlice(rule.scopes))
+			}
+			if len(rule.roles) != 0 {
+				fields = append(fields, "Roles: "+formatStringSlice(rule.roles))
+			}
+			fmt.Fprintf(buf, "\t{%s},\n", strings.Join(fields, ", "))
+		}
+		buf.WriteString("}\n")
+	}
+}
+
+// formatDuration returns the Go expression of d in the largest unit which represents it exactly.
+func formatDuration(d time.Duration) string {
+	units := []struct {
+		name string
+		val  time.Duration
+	}{
+		{name: "Hour", val: time.Hour},
+		{name: "Minute", val: time.Minute},
+		{name: "Second", val: time.Second},
+		{name: "Millisecond", val: time.Millisecond},
+		{name: "Microsecond", val: time.Microsecond},
+	}
+	for _, unit := range units {
+		if d%unit.val == 0 {
+			return fmt.Sprintf("%d * %s.%s", d/unit.val, timePackage, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d * %s.Nanosecond", d, timePackage)
+}
+
+func formatRateLimit(in *pgioptions.RateLimit) string {
+	fields := []string{"RPS: " + strconv.FormatFloat(in.GetRps(), 'g', -1, 64)}
+	if in.GetBurst() != 0 {
+		fields = append(fields, "Burst: "+strconv.FormatUint(uint64(in.GetBurst()), 10))
+	}
+	if in.GetKey() != "" {
+		fields = append(fields, "Key: "+strconv.Quote(in.GetKey()))
+	}
+	return fmt.Sprintf("&%s.RateLimit{%s}", pgiRuntimePackage, strings.Join(fields, ", "))
+}
+
+func formatStringSlice(in []string) string {
+	quoted := make([]string, len(in))
+	for i := range in {
+		quoted[i] = strconv.Quote(in[i])
+	}
+	return fmt.Sprintf("[]string{%s}", strings.Join(quoted, ", "))
+}
+
+// printDocumentedFunc prints funcDecl with its doc comment. Printer ignores doc comments
+// of the nodes which are not listed in the file comments, so they are written separately.
+func printDocumentedFunc(buf *bytes.Buffer, fSet *token.FileSet, funcDecl *ast.FuncDecl) error {
+	buf.WriteString("\n")
+	if funcDecl.Doc != nil {
+		for _, comment := range funcDecl.Doc.List {
+			buf.WriteString(comment.Text + "\n")
+		}
+	}
+	return printer.Fprint(buf, fSet, &ast.FuncDecl{
+		Recv: funcDecl.Recv,
+		Name: funcDecl.Name,
+		Type: funcDecl.Type,
+		Body: funcDecl.Body,
+	})
+}
+
+func checkIfFuncNeedField(funcDecl *ast.FuncDecl, fieldName string) bool {
+	if funcDecl == nil || funcDecl.Type == nil || funcDecl.Type.Params == nil {
+		return false
+	}
+	for i := range funcDecl.Type.Params.List {
+		fieldsMap := make(map[string]interface{})
+		for _, val := range funcDecl.Type.Params.List[i].Names {
+			fieldsMap[val.Name] = nil
+		}
+		if _, ok := fieldsMap[fieldName]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func resolveServerType(funcDecl *ast.FuncDecl) string {
+	if funcDecl == nil || funcDecl.Type == nil || funcDecl.Type.Params == nil {
+		return ""
+	}
+	for _, val := range funcDecl.Type.Params.List {
+		for i := range val.Names {
+			if val.Names[i].Name == serverVar {
+				if ident, ok := val.Type.(*ast.Ident); ok {
+					return ident.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func resolveProtoFileName(in string) string {
+	return strings.ReplaceAll(in, protoExtension, "")
+}
+
+func getRootFunctionsNames(input protoFile) map[string]protoService {
+	resp := make(map[string]protoService)
+	interceptorNames := getInterceptorNamesMap(input.pkg, input.services)
+
+	for i := range input.services {
+		service := protoService{
+			serviceName:          input.services[i].GetName(),
+			pkg:                  input.pkg,
+			registerFunctionName: fmt.Sprintf(rootFunctionTemplate, input.services[i].GetName()),
+			methods:              input.services[i].GetMethod(),
+			interceptorNames:     getServiceInterceptorNames(input.pkg, input.services[i], interceptorNames),
+		}
+		resp[fmt.Sprintf(rootFunctionTemplate, input.services[i].GetName())] = service
+	}
+	return resp
+}
+
+func genIdent(in string) *ast.Ident {
+	return &ast.Ident{
+		Name: in,
+	}
+}
+
+func genIdentWithObj(in string, kind ast.ObjKind) *ast.Ident {
+	return &ast.Ident{
+		Name: in,
+		Obj: &ast.Object{
+			Kind: kind,
+			Name: in,
+		},
+	}
+}
+
+func generateAssignmentStatement(funcName string) *ast.AssignStmt {
+	return &ast.AssignStmt{
+		Tok: token.DEFINE,
+		Lhs: exprToList(genIdent(mdVar), genIdent(respVar), genIdent(errVar)),
+		Rhs: exprToList(
+			getCallExpr(
+				genIdent(funcName),
+				genIdent(annotatedContextVar),
+				genIdent(inboundMarshalerVar),
+				genIdent(serverVar),
+				genIdent(interceptorVar),
+				genIdent(optionsVar),
+				genIdent(reqVar),
+				genIdent(pathParamsVar),
+			),
+		),
+	}
+}
+
+func generateField(pointer bool, packageName, selectorName string, names ...string) *ast.Field {
+	var (
+		fieldType ast.Expr
+	)
+
+	nameList := make([]*ast.Ident, len(names))
+
+	for i := range names {
+		nameList[i] = genIdent(names[i])
+	}
+	if packageName == "" {
+		fieldType = &ast.Ident{
+			Name: selectorName,
+		}
+	} else {
+		fieldType = getSelectorExpr(packageName, selectorName)
+	}
+	if pointer {
+		fieldType = getStarExpr(fieldType)
+	}
+
+	return &ast.Field{
+		Names: nameList,
+		Type:  fieldType,
+	}
+}
+
+// generateRegistrationFunction generates the function registering the same interceptors
+// for both gRPC server and gateway handlers of the service.
+func generateRegistrationFunction(
+	funcName, serviceName, serverType string,
+	interceptorNames map[string][]string,
+	generatedOpts []ast.Expr,
+) *ast.FuncDecl {
+	var interceptorNamesExpr ast.Expr = genIdent(nilVar)
+	if len(interceptorNames) != 0 {
+		interceptorNamesExpr = generateInterceptorNamesMap(interceptorNames)
+	}
+
+	registerServiceCall := getCallExpr(
+		getSelectorExpr(pgiRuntimePackage, registerServiceSelector),
+		genIdent(registrarVar),
+		getUnaryExpr(token.AND, genIdent(fmt.Sprintf(serviceDescTemplate, serviceName))),
+		genIdent(serverVar),
+		interceptorNamesExpr,
+		generateOptsExpr(optsVar, generatedOpts),
+	)
+	setEllipsis(registerServiceCall)
+
+	registerHandlerCall := getCallExpr(
+		genIdent(fmt.Sprintf(rootFunctionTemplate, serviceName)),
+		genIdent(ctxVar),
+		genIdent(muxVar),
+		genIdent(serverVar),
+		genIdent(nilVar),
+		genIdent(optsVar),
+	)
+	setEllipsis(registerHandlerCall)
+
+	return &ast.FuncDecl{
+		Doc: &ast.CommentGroup{
+			List: []*ast.Comment{
+				{Text: fmt.Sprintf("// %s registers \"server\" on \"%s\" and the http handlers for service %s to \"%s\".", funcName, registrarVar, serviceName, muxVar)},
+				{Text: "// Both transports use the interceptors passed with \"opts\"."},
+			},
+		},
+		Name: genIdent(funcName),
+		Type: &ast.FuncType{
+			Params: fieldsToList(
+				generateField(false, contextPackage, contextSelector, ctxVar),
+				generateField(false, grpcPackage, serviceRegistrarSelector, registrarVar),
+				generateField(true, runtimePackage, serveMuxSelector, muxVar),
+				generateField(false, "", serverType, serverVar),
+				getOptionsField(optsVar),
+			),
+			Results: fieldsToList(generateField(false, "", errType)),
+		},
+		Body: getBlockStmnt(
+			getIfStmt(
+				getBinaryExpr(token.NEQ, errVar, nilVar),
+				&ast.AssignStmt{
+					Tok: token.DEFINE,
+					Lhs: exprToList(genIdentWithObj(errVar, ast.Var)),
+					Rhs: exprToList(registerServiceCall),
+				},
+				nil,
+				stmtToList(getReturnStmt(genIdent(errVar))),
+			),
+			getReturnStmt(registerHandlerCall),
+		),
+	}
+}
+
+func generateInterceptorNamesMap(interceptorNames map[string][]string) *ast.CompositeLit {
+	fullMethods := make([]string, 0, len(interceptorNames))
+	for fullMethod := range interceptorNames {
+		fullMethods = append(fullMethods, fullMethod)
+	}
+	sort.Strings(fullMethods)
+
+	elts := make([]ast.Expr, len(fullMethods))
+	for i, fullMethod := range fullMethods {
+		elts[i] = getKeyValExpr(
+			getBasicLit(token.STRING, strconv.Quote(fullMethod)),
+			getCompositeLit(nil, stringsToBasicLits(interceptorNames[fullMethod])...),
+		)
+	}
+	return getCompositeLit(
+		&ast.MapType{
+			Key:   genIdent(stringType),
+			Value: &ast.ArrayType{Elt: genIdent(stringType)},
+		},
+		elts...,
+	)
+}
+
+// insertOptionsAssignment resolves options at the beginning of the root function.
+// The root function body has to be processed already, options are not resolved if nothing uses them.
+// The checks are placed right after the assignment.
+func insertOptionsAssignment(funcDecl *ast.FuncDecl, optsExpr ast.Expr, checks ...ast.Stmt) {
+	if funcDecl.Body == nil || !checkIfIdentUsed(funcDecl.Body, optionsVar) {
+		return
+	}
+	stmts := append(generateOptionsAssignment(optsExpr), checks...)
+	funcDecl.Body.List = append(stmts, funcDecl.Body.List...)
+}
+
+// generateOptionsChecks validates options against the service: interceptors added for methods
+// have to target its known methods and interceptors selected with pgi options have to be registered.
+func generateOptionsChecks(fullService string, methods, interceptorNames []string) []ast.Stmt {
+	resp := []ast.Stmt{
+		generateOptionsCheckStmt(
+			checkMethodInterceptorsSelector,
+			append(exprToList(getBasicLit(token.STRING, strconv.Quote(fullService))), stringsToBasicLits(methods)...)...,
+		),
+	}
+	if len(interceptorNames) != 0 {
+		resp = append(resp, generateOptionsCheckStmt(checkNamedInterceptorsSelector, stringsToBasicLits(interceptorNames)...))
+	}
+	return resp
+}
+
+func generateOptionsCheckStmt(selector string, args ...ast.Expr) *ast.IfStmt {
+	return getIfStmt(
+		getBinaryExpr(token.NEQ, errVar, nilVar),
+		&ast.AssignStmt{
+			Tok: token.ASSIGN,
+			Lhs: exprToList(genIdent(errVar)),
+			Rhs: exprToList(
+				getCallExpr(
+					getSelectorExpr(optionsVar, selector),
+					args...,
+				),
+			),
+		},
+		nil,
+		stmtToList(getReturnStmt(genIdent(errVar))),
+	)
+}
+
+func checkIfIdentUsed(node ast.Node, name string) (resp bool) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Name == name {
+			resp = true
+		}
+		return !resp
+	})
+	return resp
+}
+
+func checkIfSelectorCalled(node ast.Node, pkg, selector string) (resp bool) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		if callExpr, ok := node.(*ast.CallExpr); ok {
+			if selectorExpr, ok := callExpr.Fun.(*ast.SelectorExpr); ok && selectorExpr.Sel.Name == selector {
+				ident, ok := selectorExpr.X.(*ast.Ident)
+				resp = ok && ident.Name == pkg
+			}
+		}
+		return !resp
+	})
+	return resp
+}
+
+func generateOptionsAssignment(optsExpr ast.Expr) []ast.Stmt {
+	newOptionsCall := getCallExpr(getSelectorExpr(pgiRuntimePackage, newOptionsSelector), optsExpr)
+	setEllipsis(newOptionsCall)
+
+	return stmtToList(
+		&ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: exprToList(genIdentWithObj(optionsVar, ast.Var), genIdentWithObj(errVar, ast.Var)),
+			Rhs: exprToList(newOptionsCall),
+		},
+		getIfStmt(
+			getBinaryExpr(token.NEQ, errVar, nilVar),
+			nil,
+			nil,
+			stmtToList(getReturnStmt(genIdent(errVar))),
+		),
+	)
+}
+
+func getBinaryExpr(op token.Token, x, y string) *ast.BinaryExpr {
+	return &ast.BinaryExpr{
+		Op: op,
+		X:  genIdent(x),
+		Y:  genIdent(y),
+	}
+}
+
+func getIfStmt(cond ast.Expr, init ast.Stmt, elseItem []ast.Stmt, body []ast.Stmt) *ast.IfStmt {
+	var elseBlock ast.Stmt
+	if len(elseItem) != 0 {
+		elseBlock = getBlockStmnt(elseItem...)
+	}
+	return &ast.IfStmt{
+		Cond: cond,
+		Init: init,
+		Body: getBlockStmnt(body...),
+		Else: elseBlock,
+	}
+}
+
+func getBlockStmnt(in ...ast.Stmt) *ast.BlockStmt {
+	return &ast.BlockStmt{
+		List: in,
+	}
+}
+
+func getUnaryExpr(token token.Token, expr ast.Expr) *ast.UnaryExpr {
+	return &ast.UnaryExpr{
+		Op: token,
+		X:  expr,
+	}
+}
+
+func getCompositeLit(typeOf ast.Expr, eltItems ...ast.Expr) *ast.CompositeLit {
+	return &ast.CompositeLit{
+		Type: typeOf,
+		Elts: eltItems,
+	}
+}
+
+func exprToList(expr ...ast.Expr) []ast.Expr {
+	return expr
+}
+
+func stmtToList(stmt ...ast.Stmt) []ast.Stmt {
+	return stmt
+}
+
+func getReturnStmt(expr ...ast.Expr) *ast.ReturnStmt {
+	if len(expr) == 0 {
+		return &ast.ReturnStmt{}
+	}
+	return &ast.ReturnStmt{
+		Results: expr,
+	}
+}
+
+func getSelectorExpr(x, sel string) *ast.SelectorExpr {
+	return &ast.SelectorExpr{
+		X:   genIdent(x),
+		Sel: genIdent(sel),
+	}
+}
+
+func getInterceptorField() *ast.Field {
+	return &ast.Field{
+		Names: identToList(genIdentWithObj(interceptorVar, ast.Var)),
+		Type:  getStarExpr(getSelectorExpr(grpcPackage, unaryServerInterceptorSelector)),
+	}
+}
+
+func getOptionsField(name string) *ast.Field {
+	return &ast.Field{
+		Names: identToList(genIdentWithObj(name, ast.Var)),
+		Type:  &ast.Ellipsis{Elt: getSelectorExpr(pgiRuntimePackage, optionSelector)},
+	}
+}
+
+func getStarExpr(in ast.Expr) *ast.StarExpr {
+	return &ast.StarExpr{
+		X: in,
+	}
+}
+
+func getBasicLit(token token.Token, value string) *ast.BasicLit {
+	return &ast.BasicLit{
+		Kind:  token,
+		Value: value,
+	}
+}
+
+func stringsToBasicLits(in []string) []ast.Expr {
+	resp := make([]ast.Expr, len(in))
+	for i := range in {
+		resp[i] = getBasicLit(token.STRING, strconv.Quote(in[i]))
+	}
+	return resp
+}
+
+func getKeyValExpr(key, val ast.Expr) *ast.KeyValueExpr {
+	return &ast.KeyValueExpr{
+		Key:   key,
+		Value: val,
+	}
+}
+
+func fieldsToList(fields ...*ast.Field) *ast.FieldList {
+	return &ast.FieldList{
+		List: fields,
+	}
+}
+
+// setEllipsis marks the last argument of the call as variadic.
+func setEllipsis(callExpr *ast.CallExpr) {
+	if callExpr.Rparen.IsValid() {
+		callExpr.Ellipsis = callExpr.Rparen
+		return
+	}
+	callExpr.Ellipsis = token.Pos(1)
+}
+
+func getCallExpr(fun ast.Expr, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{
+		Fun:  fun,
+		Args: args,
+	}
+}
+
+func identToList(idents ...*ast.Ident) []*ast.Ident {
+	return idents
+}
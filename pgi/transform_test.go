@@ -0,0 +1,282 @@
+package pgi
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pgioptions "github.com/tarmalonchik/protoc-gen-interceptors/options"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// loadMultiFixture returns the descriptor of testdata/multi/multi.proto with two services and the options
+// transforming the grpc-gateway output for it. The fixture files are generated from multi.proto,
+// the descriptor is the FileDescriptorProto in the text format.
+func loadMultiFixture(t *testing.T, opts Options) ([]byte, *descriptorpb.FileDescriptorProto, Options) {
+	t.Helper()
+	file := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal(readTestdata(t, "multi/multi.descriptor.txt"), file); err != nil {
+		t.Fatalf("decoding descriptor: %v", err)
+	}
+	opts.GRPCSource = readTestdata(t, "multi/multi_grpc.pb.go")
+	opts.Dependencies = []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(emptypb.File_google_protobuf_empty_proto)}
+	return readTestdata(t, "multi/multi.pb.gw.go"), file, opts
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	return data
+}
+
+func TestTransformResolvesServerTypesPerService(t *testing.T) {
+	src, file, opts := loadMultiFixture(t, Options{Recover: true})
+	out, err := Transform(src, file, opts)
+	if err != nil {
+		t.Fatalf("transforming: %v", err)
+	}
+	fileAst, err := parser.ParseFile(token.NewFileSet(), "multi.pb.gw.go", out, 0)
+	if err != nil {
+		t.Fatalf("parsing result: %v", err)
+	}
+
+	wrappers := make(map[string]string)
+	for _, decl := range fileAst.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !strings.HasPrefix(funcDecl.Name.Name, "interceptor_local_request_") {
+			continue
+		}
+		for _, field := range funcDecl.Type.Params.List {
+			if len(field.Names) == 1 && field.Names[0].Name == serverVar {
+				wrappers[funcDecl.Name.Name] = field.Type.(*ast.Ident).Name
+			}
+		}
+	}
+	for name, serverType := range map[string]string{
+		"interceptor_local_request_UserService_Get_0":    "UserServiceServer",
+		"interceptor_local_request_UserService_Create_0": "UserServiceServer",
+		"interceptor_local_request_UserService_Health_0": "UserServiceServer",
+		"interceptor_local_request_AdminService_Ping_0":  "AdminServiceServer",
+	} {
+		if got, ok := wrappers[name]; !ok {
+			t.Errorf("%s is not generated", name)
+		} else if got != serverType {
+			t.Errorf("server of %s is %s, want %s", name, got, serverType)
+		}
+	}
+}
+
+func TestTransformIncludeExclude(t *testing.T) {
+	const (
+		get    = "interceptor_local_request_UserService_Get_0"
+		create = "interceptor_local_request_UserService_Create_0"
+		health = "interceptor_local_request_UserService_Health_0"
+		ping   = "interceptor_local_request_AdminService_Ping_0"
+	)
+	for _, tc := range []struct {
+		name     string
+		include  []string
+		exclude  []string
+		wrappers []string
+		direct   []string
+		err      string
+	}{
+		{
+			name:     "all",
+			wrappers: []string{ping, create, get, health},
+		},
+		{
+			name:     "include only",
+			include:  []string{"multi.UserService/*"},
+			wrappers: []string{create, get, health},
+			direct:   []string{"local_request_AdminService_Ping_0"},
+		},
+		{
+			name:     "exclude only",
+			exclude:  []string{"*/Health"},
+			wrappers: []string{ping, create, get},
+			direct:   []string{"local_request_UserService_Health_0"},
+		},
+		{
+			name:     "include and exclude",
+			include:  []string{"multi.UserService/*", "multi.AdminService/Ping"},
+			exclude:  []string{"multi.UserService/Health"},
+			wrappers: []string{ping, create, get},
+			direct:   []string{"local_request_UserService_Health_0"},
+		},
+		{
+			name:     "patterns matching nothing",
+			include:  []string{"multi.*/*", "other.*/*"},
+			exclude:  []string{"other.*/*", "multi.UserService/Delete"},
+			wrappers: []string{ping, create, get, health},
+		},
+		{
+			// the registration helper is generated still, the gRPC server intercepts the methods
+			name:     "service with all methods excluded",
+			exclude:  []string{"multi.AdminService/*"},
+			wrappers: []string{create, get, health},
+			direct:   []string{"local_request_AdminService_Ping_0"},
+		},
+		{
+			name:    "include leaving out method with option",
+			include: []string{"multi.UserService/Health"},
+			err:     "method /multi.UserService/Get has rate limit but is excluded from interception",
+		},
+		{
+			name:    "exclude of method with option",
+			exclude: []string{"*/Create"},
+			err:     "method /multi.UserService/Create has idempotency key but is excluded from interception",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src, file, opts := loadMultiFixture(t, Options{Include: tc.include, Exclude: tc.exclude})
+			out, err := Transform(src, file, opts)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error is %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transforming: %v", err)
+			}
+			fileAst, err := parser.ParseFile(token.NewFileSet(), "multi.pb.gw.go", out, 0)
+			if err != nil {
+				t.Fatalf("parsing result: %v", err)
+			}
+			var wrappers, direct, helpers []string
+			for _, decl := range fileAst.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				switch name := funcDecl.Name.Name; {
+				case strings.HasPrefix(name, "interceptor_local_request_"):
+					wrappers = append(wrappers, name)
+				case strings.HasSuffix(name, "ServerAndHandler"):
+					helpers = append(helpers, name)
+				case strings.HasSuffix(name, "HandlerServer"):
+					// the handlers of the excluded methods call the local requests directly
+					ast.Inspect(funcDecl, func(node ast.Node) bool {
+						if call, ok := node.(*ast.CallExpr); ok {
+							if ident, ok := call.Fun.(*ast.Ident); ok && strings.HasPrefix(ident.Name, "local_request_") {
+								direct = append(direct, ident.Name)
+							}
+						}
+						return true
+					})
+				}
+			}
+			sort.Strings(wrappers)
+			if !reflect.DeepEqual(wrappers, tc.wrappers) {
+				t.Errorf("wrappers are %q, want %q", wrappers, tc.wrappers)
+			}
+			if !reflect.DeepEqual(direct, tc.direct) {
+				t.Errorf("direct calls are %q, want %q", direct, tc.direct)
+			}
+			if want := []string{"RegisterUserServiceServerAndHandler", "RegisterAdminServiceServerAndHandler"}; !reflect.DeepEqual(helpers, want) {
+				t.Errorf("registration helpers are %q, want %q", helpers, want)
+			}
+		})
+	}
+}
+
+func TestTransformDefaultMaxRequestBytes(t *testing.T) {
+	src, file, opts := loadMultiFixture(t, Options{MaxRequestBytes: 1024, Exclude: []string{"multi.AdminService/*"}})
+	// the limit of the option is kept
+	proto.SetExtension(file.GetService()[0].GetMethod()[1].GetOptions(), pgioptions.E_MaxRequestBytes, uint64(64))
+	out, err := Transform(src, file, opts)
+	if err != nil {
+		t.Fatalf("transforming: %v", err)
+	}
+
+	catalog := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "{FullMethod: "), "_FullMethodName,"); ok {
+			catalog[name] = line
+		}
+	}
+	for method, want := range map[string]string{
+		"UserService_Get":    "MaxRequestBytes: 1024",
+		"UserService_Create": "MaxRequestBytes: 64",
+		"UserService_Health": "MaxRequestBytes: 1024",
+		// excluded methods have no limit, as the gateway handlers do not run the interceptors
+		"AdminService_Ping": "",
+	} {
+		line, ok := catalog[method]
+		if !ok {
+			t.Errorf("%s is not in the catalog", method)
+			continue
+		}
+		if want == "" && strings.Contains(line, "MaxRequestBytes") || !strings.Contains(line, want) {
+			t.Errorf("catalog entry of %s is %q, want %q", method, strings.TrimSpace(line), want)
+		}
+	}
+}
+
+func TestTransformRejectsOptionsOfExcludedMethods(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		opts      Options
+		streaming bool
+		timeout   bool
+		limit     bool
+		err       string
+	}{
+		{
+			name:    "excluded timeout",
+			opts:    Options{Exclude: []string{"*/Health"}},
+			timeout: true,
+			err:     "method /multi.UserService/Health has timeout but is excluded from interception",
+		},
+		{
+			name:  "excluded body limit",
+			opts:  Options{Exclude: []string{"*/Health"}},
+			limit: true,
+			err:   "method /multi.UserService/Health has request body limit but is excluded from interception",
+		},
+		{
+			name:      "streaming timeout",
+			streaming: true,
+			timeout:   true,
+			err:       "timeout of streaming method /multi.UserService/Health is not supported",
+		},
+		{
+			name:      "streaming body limit",
+			streaming: true,
+			limit:     true,
+			err:       "request body limit of streaming method /multi.UserService/Health is not supported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src, file, opts := loadMultiFixture(t, tc.opts)
+			health := file.GetService()[0].GetMethod()[2]
+			health.ServerStreaming = proto.Bool(tc.streaming)
+			if tc.timeout {
+				proto.SetExtension(health.GetOptions(), pgioptions.E_Timeout, durationpb.New(time.Second))
+			}
+			if tc.limit {
+				proto.SetExtension(health.GetOptions(), pgioptions.E_MaxRequestBytes, uint64(64))
+			}
+			_, err := Transform(src, file, opts)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("error is %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RedactedValue replaces the values of the redacted string fields in audit records,
+// the redacted fields of other types are cleared.
+const RedactedValue = "[REDACTED]"
+
+// AuditRecord describes the gateway call passed to AuditSink.
+type AuditRecord struct {
+	// FullMethod is the full gRPC method name, e.g. "/example.AuthService/Auth".
+	FullMethod string
+	// Principal is the caller resolved by Authorizer, it is nil for the methods without authorization rules.
+	Principal interface{}
+	// Request is the decoded request marshaled with protojson, the fields marked with (pgi.sensitive)
+	// or debug_redact are redacted. The calls replayed by the idempotency key check get the request of the first
+	// call. It is nil when the call failed before the request was decoded or the code was generated without audit=true.
+	Request []byte
+	// Code is the status code of the call.
+	Code codes.Code
+	// Message is the status message of the call.
+	Message string
+	// Duration is the time spent on the call.
+	Duration time.Duration
+}
+
+// AuditSink receives the audit records of the gateway calls.
+type AuditSink interface {
+	Audit(ctx context.Context, record *AuditRecord)
+}
+
+type auditCallKey struct{}
+
+// auditCall collects the parts of the record which are known deeper in the chain.
+type auditCall struct {
+	request   proto.Message
+	principal interface{}
+}
+
+// WithAuditSink adds a sink receiving the audit records of the gateway calls.
+func WithAuditSink(sink AuditSink) Option {
+	return func(o *Options) error {
+		o.auditSinks = append(o.auditSinks, sink)
+		return nil
+	}
+}
+
+// AuditRequest passes the decoded request to the audit record of the call, it is used by the code
+// generated with audit=true. The request is marshaled once the call ends.
+func AuditRequest(ctx context.Context, req proto.Message) {
+	if call, ok := ctx.Value(auditCallKey{}).(*auditCall); ok {
+		call.request = req
+	}
+}
+
+func auditPrincipal(ctx context.Context, principal interface{}) {
+	if call, ok := ctx.Value(auditCallKey{}).(*auditCall); ok {
+		call.principal = principal
+	}
+}
+
+// audit returns the interceptor passing the records of the gateway calls to the sinks.
+// Calls of the gRPC server are not audited.
+func (o *Options) audit(fullMethod string) grpc.UnaryServerInterceptor {
+	redacted := make(map[protoreflect.FullName]struct{})
+	if info := o.methodInfo(fullMethod); info != nil {
+		for _, name := range info.RedactedFields {
+			redacted[protoreflect.FullName(name)] = struct{}{}
+		}
+	}
+
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !IsGatewayCall(ctx) {
+			return handler(ctx, req)
+		}
+		call := &auditCall{}
+		start := time.Now()
+		resp, err := handler(context.WithValue(ctx, auditCallKey{}, call), req)
+
+		record := &AuditRecord{
+			FullMethod: fullMethod,
+			Principal:  call.principal,
+			Code:       resolveCode(err),
+			Message:    status.Convert(err).Message(),
+			Duration:   time.Since(start),
+		}
+		if call.request != nil {
+			record.Request = marshalRedacted(call.request, redacted)
+		}
+		for i := range o.auditSinks {
+			o.auditSinks[i].Audit(ctx, record)
+		}
+		return resp, err
+	}
+}
+
+// marshalRedacted marshals a copy of msg with the redacted fields masked,
+// a message which fails to marshal is not included in the record.
+func marshalRedacted(msg proto.Message, redacted map[protoreflect.FullName]struct{}) []byte {
+	if len(redacted) != 0 {
+		msg = proto.Clone(msg)
+		redactMessage(msg.ProtoReflect(), redacted)
+	}
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func redactMessage(msg protoreflect.Message, redacted map[protoreflect.FullName]struct{}) {
+	var fields []protoreflect.FieldDescriptor
+	msg.Range(func(field protoreflect.FieldDescriptor, val protoreflect.Value) bool {
+		if _, ok := redacted[field.FullName()]; ok {
+			// the message is not changed while it is iterated
+			fields = append(fields, field)
+			return true
+		}
+		switch {
+		case field.IsMap():
+			if field.MapValue().Message() != nil {
+				val.Map().Range(func(_ protoreflect.MapKey, item protoreflect.Value) bool {
+					redactMessage(item.Message(), redacted)
+					return true
+				})
+			}
+		case field.IsList():
+			if field.Message() != nil {
+				for i := 0; i < val.List().Len(); i++ {
+					redactMessage(val.List().Get(i).Message(), redacted)
+				}
+			}
+		case field.Message() != nil:
+			redactMessage(val.Message(), redacted)
+		}
+		return true
+	})
+	for _, field := range fields {
+		redactField(msg, field)
+	}
+}
+
+func redactField(msg protoreflect.Message, field protoreflect.FieldDescriptor) {
+	mask := protoreflect.ValueOfString(RedactedValue)
+	switch {
+	case field.IsMap():
+		if field.MapValue().Kind() != protoreflect.StringKind {
+			break
+		}
+		items := msg.Mutable(field).Map()
+		var keys []protoreflect.MapKey
+		items.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			items.Set(key, mask)
+		}
+		return
+	case field.IsList():
+		if field.Kind() != protoreflect.StringKind {
+			break
+		}
+		items := msg.Mutable(field).List()
+		for i := 0; i < items.Len(); i++ {
+			items.Set(i, mask)
+		}
+		return
+	case field.Kind() == protoreflect.StringKind:
+		msg.Set(field, mask)
+		return
+	}
+	msg.Clear(field)
+}
@@ -0,0 +1,132 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestAuditRecords(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	request := &descriptorpb.FileDescriptorProto{
+		Name: proto.String("users.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("password"), JsonName: proto.String("secret"), Number: proto.Int32(1)},
+			},
+		}},
+	}
+	original := proto.Clone(request)
+
+	for _, tc := range []struct {
+		name    string
+		call    func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error)
+		code    codes.Code
+		request bool
+	}{
+		{
+			name: "success",
+			call: func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				AuditRequest(ctx, request)
+				return &emptypb.Empty{}, gwruntime.ServerMetadata{}, nil
+			},
+			code:    codes.OK,
+			request: true,
+		},
+		{
+			name: "error",
+			call: func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				AuditRequest(ctx, request)
+				return nil, gwruntime.ServerMetadata{}, status.Error(codes.NotFound, "no user")
+			},
+			code:    codes.NotFound,
+			request: true,
+		},
+		{
+			name: "decoding error",
+			call: func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				return nil, gwruntime.ServerMetadata{}, status.Error(codes.InvalidArgument, "bad json")
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "panic",
+			call: func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				AuditRequest(ctx, request)
+				panic("boom")
+			},
+			code:    codes.Internal,
+			request: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var records auditRecords
+			opts, err := NewOptions(
+				WithMethodCatalog([]MethodInfo{{
+					FullMethod: fullMethod,
+					RedactedFields: []string{
+						"google.protobuf.FieldDescriptorProto.json_name",
+						"google.protobuf.FieldDescriptorProto.number",
+					},
+				}}),
+				WithAuditSink(&records),
+				WithRecover(),
+			)
+			if err != nil {
+				t.Fatalf("creating options: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/example", nil)
+			_, _, err = InterceptUnaryRequest(context.Background(), opts, fullMethod, req, nil, tc.call)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("code is %s, want %s", code, tc.code)
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d audit records, want 1", len(records))
+			}
+			record := records[0]
+			if record.FullMethod != fullMethod || record.Code != tc.code {
+				t.Errorf("record is for %s with %s, want %s with %s", record.FullMethod, record.Code, fullMethod, tc.code)
+			}
+			if !tc.request {
+				if record.Request != nil {
+					t.Errorf("request is %s, want none", record.Request)
+				}
+				return
+			}
+
+			var audited struct {
+				MessageType []struct {
+					Field []map[string]interface{} `json:"field"`
+				} `json:"messageType"`
+			}
+			if err = json.Unmarshal(record.Request, &audited); err != nil {
+				t.Fatalf("decoding request %s: %v", record.Request, err)
+			}
+			field := audited.MessageType[0].Field[0]
+			if field["name"] != "password" {
+				t.Errorf("name is %v, want it as is", field["name"])
+			}
+			// the string fields are masked, the other ones are cleared
+			if field["jsonName"] != RedactedValue {
+				t.Errorf("json name is %v, want %q", field["jsonName"], RedactedValue)
+			}
+			if number, ok := field["number"]; ok {
+				t.Errorf("number is %v, want it cleared", number)
+			}
+			if !proto.Equal(request, original) {
+				t.Errorf("request is changed to %v", request)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuthRule is the authorization rule declared for a method with the (pgi.auth) proto option.
+type AuthRule struct {
+	// FullMethod is the full gRPC method name, e.g. "/example.AuthService/Auth".
+	FullMethod string
+	// Scopes are the scopes required by the method.
+	Scopes []string
+	// Roles are the roles required by the method.
+	Roles []string
+}
+
+// Authorizer checks the calls of the methods with authorization rules.
+type Authorizer interface {
+	// Principal returns the caller, e.g. the subject of the bearer token.
+	// Errors which are not gRPC statuses are returned as codes.Unauthenticated.
+	Principal(ctx context.Context) (interface{}, error)
+	// Authorize returns an error if principal is not allowed to call the method of rule.
+	// Errors which are not gRPC statuses are returned as codes.PermissionDenied.
+	Authorize(ctx context.Context, principal interface{}, rule *AuthRule) error
+}
+
+type principalKey struct{}
+
+// WithAuthorizer sets the authorizer checking the methods with authorization rules.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(o *Options) error {
+		o.authorizer = authorizer
+		return nil
+	}
+}
+
+// WithAuthRules adds authorization rules, it is used by the generated code with the rules of the service.
+func WithAuthRules(rules []AuthRule) Option {
+	return func(o *Options) error {
+		if o.authRules == nil {
+			o.authRules = make(map[string]*AuthRule, len(rules))
+		}
+		for i := range rules {
+			if _, ok := o.authRules[rules[i].FullMethod]; ok {
+				return fmt.Errorf("authorization rule for %s is added twice", rules[i].FullMethod)
+			}
+			rule := rules[i]
+			o.authRules[rule.FullMethod] = &rule
+		}
+		return nil
+	}
+}
+
+// PrincipalFromContext returns the principal resolved by Authorizer for the method with an authorization rule.
+func PrincipalFromContext(ctx context.Context) (interface{}, bool) {
+	principal := ctx.Value(principalKey{})
+	return principal, principal != nil
+}
+
+// authorize returns the interceptor checking rule, it runs after the configured interceptors,
+// so they are able to prepare the context for Authorizer.
+func (o *Options) authorize(rule *AuthRule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal, err := o.authorizer.Principal(ctx)
+		if err != nil {
+			return nil, toStatusError(err, codes.Unauthenticated)
+		}
+		// the principal is audited even if the call is denied
+		auditPrincipal(ctx, principal)
+		if err = o.authorizer.Authorize(ctx, principal, rule); err != nil {
+			return nil, toStatusError(err, codes.PermissionDenied)
+		}
+		if principal != nil {
+			ctx = context.WithValue(ctx, principalKey{}, principal)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func toStatusError(err error, code codes.Code) error {
+	var grpcStatus interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcStatus) {
+		return err
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// scopeAuthorizer takes the principal from the X-User header and allows the users with all the scopes of the rule.
+type scopeAuthorizer map[string][]string
+
+func (a scopeAuthorizer) Principal(ctx context.Context) (interface{}, error) {
+	if req, ok := HTTPRequestFromContext(ctx); ok && req.Header.Get("X-User") != "" {
+		return req.Header.Get("X-User"), nil
+	}
+	return nil, errors.New("no user")
+}
+
+func (a scopeAuthorizer) Authorize(_ context.Context, principal interface{}, rule *AuthRule) error {
+	for _, scope := range rule.Scopes {
+		if !containsString(a[principal.(string)], scope) {
+			return errors.New("missing scope " + scope)
+		}
+	}
+	return nil
+}
+
+func TestAuthorize(t *testing.T) {
+	const (
+		ruledMethod = "/example.AuthService/Auth"
+		openMethod  = "/example.AuthService/Health"
+	)
+	opts, err := NewOptions(
+		WithAuthRules([]AuthRule{{FullMethod: ruledMethod, Scopes: []string{"example.read"}}}),
+		WithAuthorizer(scopeAuthorizer{"alice": {"example.read"}, "bob": {"example.write"}}),
+	)
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		fullMethod string
+		user       string
+		code       codes.Code
+		principal  interface{}
+	}{
+		{name: "allowed", fullMethod: ruledMethod, user: "alice", code: codes.OK, principal: "alice"},
+		{name: "missing principal", fullMethod: ruledMethod, code: codes.Unauthenticated},
+		{name: "denied", fullMethod: ruledMethod, user: "bob", code: codes.PermissionDenied},
+		// the methods without rules are not checked and get no principal
+		{name: "without rule", fullMethod: openMethod, code: codes.OK},
+		{name: "without rule with user", fullMethod: openMethod, user: "bob", code: codes.OK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+			if tc.user != "" {
+				req.Header.Set("X-User", tc.user)
+			}
+			var (
+				called    bool
+				principal interface{}
+			)
+			_, _, err := InterceptUnaryRequest(context.Background(), opts, tc.fullMethod, req, nil,
+				func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+					called = true
+					principal, _ = PrincipalFromContext(ctx)
+					return &emptypb.Empty{}, gwruntime.ServerMetadata{}, nil
+				},
+			)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("code is %s, want %s: %v", code, tc.code, err)
+			}
+			if called != (tc.code == codes.OK) {
+				t.Errorf("handler is called: %t", called)
+			}
+			if principal != tc.principal {
+				t.Errorf("principal is %v, want %v", principal, tc.principal)
+			}
+		})
+	}
+}
+
+func TestAuthRulesRequireAuthorizer(t *testing.T) {
+	_, err := NewOptions(WithAuthRules([]AuthRule{{FullMethod: "/example.AuthService/Auth"}}))
+	if err == nil || !strings.Contains(err.Error(), "require WithAuthorizer") {
+		t.Fatalf("error is %v, want the one about the missing authorizer", err)
+	}
+	// the rules may be added with the generated options, the authorizer with the ones of the caller
+	if _, err = NewOptions(WithAuthRules([]AuthRule{{FullMethod: "/example.AuthService/Auth"}}), WithAuthorizer(scopeAuthorizer{})); err != nil {
+		t.Errorf("creating options with authorizer: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errRequestTooLarge = errors.New("request body is too large")
+
+// limitedBody fails reading after more than remaining bytes.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errRequestTooLarge
+	}
+	// one more byte is read to find out that the limit is exceeded
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n, b.remaining, b.exceeded = int(b.remaining), 0, true
+	return n, errRequestTooLarge
+}
+
+// limitRequestBody returns the interceptor which limits the body of the HTTP request to limit bytes
+// before it is decoded. Exceeding the limit fails the call with codes.ResourceExhausted and HTTP 413.
+// Calls of the gRPC server are not affected.
+func limitRequestBody(limit int64) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		httpReq, ok := req.(*http.Request)
+		if !ok || httpReq.Body == nil || httpReq.Body == http.NoBody {
+			return handler(ctx, req)
+		}
+		if httpReq.ContentLength > limit {
+			return nil, requestTooLargeError(limit)
+		}
+
+		body := &limitedBody{ReadCloser: httpReq.Body, remaining: limit}
+		httpReq.Body = body
+		resp, err := handler(ctx, req)
+		if body.exceeded {
+			err = requestTooLargeError(limit)
+		}
+		return resp, err
+	}
+}
+
+func requestTooLargeError(limit int64) error {
+	return &gwruntime.HTTPStatusError{
+		HTTPStatus: http.StatusRequestEntityTooLarge,
+		Err:        status.Errorf(codes.ResourceExhausted, "request body exceeds %d bytes", limit),
+	}
+}
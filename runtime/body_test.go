@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	const (
+		limitedMethod = "/example.AuthService/Auth"
+		openMethod    = "/example.AuthService/Health"
+	)
+	opts, err := NewOptions(WithMethodCatalog([]MethodInfo{{FullMethod: limitedMethod, MaxRequestBytes: 8}}))
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		fullMethod string
+		body       string
+		// chunked requests have no Content-Length, the limit is checked while the body is read
+		chunked bool
+		called  bool
+		status  int
+	}{
+		{name: "within limit", fullMethod: limitedMethod, body: "12345678", called: true, status: http.StatusOK},
+		{name: "content length over limit", fullMethod: limitedMethod, body: "123456789", status: http.StatusRequestEntityTooLarge},
+		{name: "chunked within limit", fullMethod: limitedMethod, body: "1234", chunked: true, called: true, status: http.StatusOK},
+		{name: "chunked over limit", fullMethod: limitedMethod, body: "123456789", chunked: true, called: true, status: http.StatusRequestEntityTooLarge},
+		{name: "method without limit", fullMethod: openMethod, body: strings.Repeat("1", 64), called: true, status: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/example", strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			var called bool
+			_, _, err := InterceptUnaryRequest(context.Background(), opts, tc.fullMethod, req, nil,
+				func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+					called = true
+					if _, err := io.ReadAll(req.Body); err != nil {
+						// the error of the decoder is replaced by the limit one
+						return nil, gwruntime.ServerMetadata{}, status.Error(codes.InvalidArgument, err.Error())
+					}
+					return &emptypb.Empty{}, gwruntime.ServerMetadata{}, nil
+				},
+			)
+			if called != tc.called {
+				t.Errorf("handler is called: %t, want %t", called, tc.called)
+			}
+			if tc.status == http.StatusOK {
+				if err != nil {
+					t.Fatalf("call failed: %v", err)
+				}
+				return
+			}
+			var httpStatusErr *gwruntime.HTTPStatusError
+			if !errors.As(err, &httpStatusErr) || httpStatusErr.HTTPStatus != tc.status {
+				t.Fatalf("error is %v, want HTTP %d", err, tc.status)
+			}
+			if code := status.Code(httpStatusErr.Err); code != codes.ResourceExhausted {
+				t.Errorf("code is %s, want %s", code, codes.ResourceExhausted)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MethodInfo is an entry of the method catalog generated for every service.
+// A method has an entry for each of its google.api.http bindings,
+// methods without bindings have a single entry with empty HTTPVerb and Pattern.
+type MethodInfo struct {
+	// FullMethod is the full gRPC method name, e.g. "/example.AuthService/Auth".
+	FullMethod string
+	// HTTPVerb is the verb of the binding, e.g. "GET".
+	HTTPVerb string
+	// Pattern is the path template of the binding, e.g. "/v1/example".
+	Pattern string
+	// RequestType is the full name of the request message.
+	RequestType protoreflect.FullName
+	// ResponseType is the full name of the response message.
+	ResponseType protoreflect.FullName
+	// Timeout is the default timeout of the method declared with (pgi.timeout).
+	Timeout time.Duration
+	// MaxRequestBytes is the maximum size of the HTTP request body declared with (pgi.max_request_bytes)
+	// or the max_request_bytes plugin parameter.
+	MaxRequestBytes int64
+	// RedactedFields are the full names of the fields marked with (pgi.sensitive) or debug_redact
+	// in the request and the messages it refers to, they are masked in audit records.
+	RedactedFields []string
+	// RateLimit is the rate limit declared with (pgi.rate_limit).
+	RateLimit *RateLimit
+	// IdempotencyKey reports whether the gateway replays the responses of the calls with the same
+	// Idempotency-Key header, it is declared with (pgi.idempotency_key).
+	IdempotencyKey bool
+}
+
+// WithMethodCatalog adds the per-method settings from the catalog, it is used by the generated code
+// for services which declare any of them.
+func WithMethodCatalog(catalog []MethodInfo) Option {
+	return func(o *Options) error {
+		if o.methods == nil {
+			o.methods = make(map[string]*MethodInfo, len(catalog))
+		}
+		for i := range catalog {
+			// the entries of other bindings of the method share the settings
+			if _, ok := o.methods[catalog[i].FullMethod]; ok {
+				continue
+			}
+			info := catalog[i]
+			o.methods[info.FullMethod] = &info
+		}
+		return nil
+	}
+}
+
+func (o *Options) methodInfo(fullMethod string) *MethodInfo {
+	if o == nil {
+		return nil
+	}
+	return o.methods[fullMethod]
+}
@@ -0,0 +1,119 @@
+package runtime
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// WithUnaryClientInterceptors adds interceptors applied to the gRPC client calls made by
+// Register*HandlerClient handlers. The first interceptor is the outermost one.
+func WithUnaryClientInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(o *Options) error {
+		o.unaryClientInterceptors = append(o.unaryClientInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithClientConn sets the connection passed to the client interceptors, it is used by the generated
+// Register*Handler functions. The interceptors get a nil *grpc.ClientConn when the handlers are registered
+// with Register*HandlerClient directly, as the client may not be backed by a connection.
+func WithClientConn(conn *grpc.ClientConn) Option {
+	return func(o *Options) error {
+		o.clientConn = conn
+		return nil
+	}
+}
+
+// WithDialedClientInterceptors marks the client interceptors as installed on the connection with DialOptions,
+// so they are not applied to the calls again. It is used by the generated Register*HandlerFromEndpoint functions.
+func WithDialedClientInterceptors() Option {
+	return func(o *Options) error {
+		o.clientInterceptorsDialed = true
+		return nil
+	}
+}
+
+// WithDialOptions adds options used by Register*HandlerFromEndpoint to dial the endpoint.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *Options) error {
+		o.dialOptions = append(o.dialOptions, opts...)
+		return nil
+	}
+}
+
+// DialOptions returns dial options added with WithDialOptions and the client interceptors
+// added with WithUnaryClientInterceptors as grpc.WithChainUnaryInterceptor.
+func (o *Options) DialOptions() []grpc.DialOption {
+	if o == nil {
+		return nil
+	}
+	if len(o.unaryClientInterceptors) == 0 {
+		return o.dialOptions
+	}
+	resp := make([]grpc.DialOption, 0, len(o.dialOptions)+1)
+	return append(append(resp, o.dialOptions...), grpc.WithChainUnaryInterceptor(o.unaryClientInterceptors...))
+}
+
+// InvokeUnaryClient calls invoke through the client interceptors from opts unless they are installed
+// on the connection already. Interceptors receive the decoded proto request, the full gRPC method name
+// and the connection set with WithClientConn.
+func InvokeUnaryClient[Req, Resp proto.Message](
+	ctx context.Context,
+	opts *Options,
+	fullMethod string,
+	req Req,
+	invoke func(context.Context, Req, ...grpc.CallOption) (Resp, error),
+	callOpts ...grpc.CallOption,
+) (Resp, error) {
+	var resp Resp
+
+	if opts == nil || opts.unaryClientInterceptor == nil || opts.clientInterceptorsDialed {
+		return invoke(ctx, req, callOpts...)
+	}
+
+	reply := resp.ProtoReflect().New().Interface()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, _ *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		in, ok := req.(Req)
+		if !ok {
+			return status.Errorf(codes.Internal, "unexpected request type %T for %s", req, method)
+		}
+		out, err := invoke(ctx, in, callOpts...)
+		if err != nil {
+			return err
+		}
+		if msg, ok := reply.(proto.Message); ok {
+			proto.Merge(msg, out)
+		}
+		return nil
+	}
+
+	if err := opts.unaryClientInterceptor(ctx, fullMethod, req, reply, opts.clientConn, invoker, callOpts...); err != nil {
+		return resp, err
+	}
+	if out, ok := reply.(Resp); ok {
+		return out, nil
+	}
+	return resp, status.Errorf(codes.Internal, "unexpected response type %T for %s", reply, fullMethod)
+}
+
+func chainUnaryClientInterceptors(interceptors []grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return interceptors[0](ctx, method, req, reply, cc, getChainUnaryInvoker(interceptors, 0, invoker), opts...)
+	}
+}
+
+func getChainUnaryInvoker(interceptors []grpc.UnaryClientInterceptor, curr int, finalInvoker grpc.UnaryInvoker) grpc.UnaryInvoker {
+	if curr == len(interceptors)-1 {
+		return finalInvoker
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return interceptors[curr+1](ctx, method, req, reply, cc, getChainUnaryInvoker(interceptors, curr+1, finalInvoker), opts...)
+	}
+}
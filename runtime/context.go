@@ -0,0 +1,115 @@
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+type gatewayCallKey struct{}
+
+type gatewayCall struct {
+	req  *http.Request
+	info *CallInfo
+}
+
+// CallInfo describes the call made through the gateway.
+type CallInfo struct {
+	// FullMethod is the full gRPC method name, e.g. "/example.AuthService/Auth".
+	FullMethod string
+	// HTTPMethod is the verb of the HTTP request.
+	HTTPMethod string
+	// HTTPPattern is the path template of the matched google.api.http rule.
+	HTTPPattern string
+	// PathParams are the values of the path template variables.
+	PathParams map[string]string
+	// Method is the descriptor of the called method, it is nil when the method is not registered
+	// in protoregistry.GlobalFiles.
+	Method protoreflect.MethodDescriptor
+}
+
+var methodDescriptors sync.Map
+
+// NewGatewayContext returns a copy of ctx carrying the HTTP request handled by the gateway
+// and CallInfo of the call. The peer of the call is added to the context as well, see NewPeerContext.
+func NewGatewayContext(ctx context.Context, fullMethod string, req *http.Request, pathParams map[string]string, opts *Options) context.Context {
+	info := &CallInfo{
+		FullMethod: fullMethod,
+		PathParams: pathParams,
+		Method:     resolveMethodDescriptor(fullMethod),
+	}
+	if req != nil {
+		info.HTTPMethod = req.Method
+	}
+	info.HTTPPattern, _ = gwruntime.HTTPPathPattern(ctx)
+
+	ctx = NewPeerContext(ctx, req, opts)
+	return context.WithValue(ctx, gatewayCallKey{}, &gatewayCall{
+		req:  req,
+		info: info,
+	})
+}
+
+// IsGatewayCall reports whether ctx belongs to the call made through the gateway.
+func IsGatewayCall(ctx context.Context) bool {
+	_, ok := ctx.Value(gatewayCallKey{}).(*gatewayCall)
+	return ok
+}
+
+// CallInfoFromContext returns the information about the call made through the gateway.
+func CallInfoFromContext(ctx context.Context) (*CallInfo, bool) {
+	call, ok := ctx.Value(gatewayCallKey{}).(*gatewayCall)
+	if !ok {
+		return nil, false
+	}
+	return call.info, true
+}
+
+// HTTPRequestFromContext returns the HTTP request handled by the gateway.
+func HTTPRequestFromContext(ctx context.Context) (*http.Request, bool) {
+	call, ok := ctx.Value(gatewayCallKey{}).(*gatewayCall)
+	if !ok {
+		return nil, false
+	}
+	return call.req, true
+}
+
+// PathParamsFromContext returns the path params matched by the gateway.
+func PathParamsFromContext(ctx context.Context) (map[string]string, bool) {
+	info, ok := CallInfoFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return info.PathParams, true
+}
+
+// HTTPPatternFromContext returns the path template of the google.api.http rule
+// matched by the gateway, e.g. "/v1/users/{id}".
+func HTTPPatternFromContext(ctx context.Context) (string, bool) {
+	info, ok := CallInfoFromContext(ctx)
+	if !ok || info.HTTPPattern == "" {
+		return "", false
+	}
+	return info.HTTPPattern, true
+}
+
+// resolveMethodDescriptor finds the descriptor by the full gRPC method name "/package.Service/Method".
+func resolveMethodDescriptor(fullMethod string) protoreflect.MethodDescriptor {
+	if val, ok := methodDescriptors.Load(fullMethod); ok {
+		method, _ := val.(protoreflect.MethodDescriptor)
+		return method
+	}
+
+	var method protoreflect.MethodDescriptor
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(fullMethod, "/"), "/", "."))
+	if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		method, _ = desc.(protoreflect.MethodDescriptor)
+	}
+	methodDescriptors.Store(fullMethod, method)
+	return method
+}
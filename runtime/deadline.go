@@ -0,0 +1,111 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithTimeoutHeader sets the HTTP header with the timeout of the gateway call, e.g. "X-Request-Timeout".
+// The value is either a duration like "1.5s" or a number of seconds, it takes precedence over Grpc-Timeout.
+func WithTimeoutHeader(header string) Option {
+	return func(o *Options) error {
+		o.timeoutHeader = header
+		return nil
+	}
+}
+
+// WithDefaultTimeout sets the timeout of the calls which come without a deadline
+// to the methods without (pgi.timeout) option.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout < 0 {
+			return errors.New("default timeout is negative")
+		}
+		o.defaultTimeout = timeout
+		return nil
+	}
+}
+
+func (o *Options) needsDeadline(fullMethod string) bool {
+	if o == nil {
+		return false
+	}
+	if info := o.methodInfo(fullMethod); info != nil && info.Timeout > 0 {
+		return true
+	}
+	return o.timeoutHeader != "" || o.defaultTimeout > 0
+}
+
+// deadline returns the interceptor setting the deadline of the call before the rate limit and the configured
+// interceptors run.
+// Errors returned after the deadline is exceeded are converted to codes.DeadlineExceeded. The gateway calls
+// are handled even if no timeout is configured, as the request may come with a deadline, e.g. from Grpc-Timeout.
+func (o *Options) deadline(fullMethod string) grpc.UnaryServerInterceptor {
+	configured := o.needsDeadline(fullMethod)
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !configured && !IsGatewayCall(ctx) {
+			return handler(ctx, req)
+		}
+		timeout, err := o.resolveTimeout(ctx, fullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		} else if _, ok := ctx.Deadline(); !ok {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = toStatusError(err, codes.DeadlineExceeded)
+		}
+		return resp, err
+	}
+}
+
+// resolveTimeout returns the timeout from the header set with WithTimeoutHeader, the deadline which is set
+// already, e.g. from Grpc-Timeout, is kept, otherwise the timeout of the method or the default one is used.
+func (o *Options) resolveTimeout(ctx context.Context, fullMethod string) (time.Duration, error) {
+	if o.timeoutHeader != "" {
+		if req, ok := HTTPRequestFromContext(ctx); ok {
+			if val := req.Header.Get(o.timeoutHeader); val != "" {
+				timeout, err := parseTimeout(val)
+				if err != nil {
+					return 0, status.Errorf(codes.InvalidArgument, "invalid %s header value %q", o.timeoutHeader, val)
+				}
+				return timeout, nil
+			}
+		}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return 0, nil
+	}
+	if info := o.methodInfo(fullMethod); info != nil && info.Timeout > 0 {
+		return info.Timeout, nil
+	}
+	return o.defaultTimeout, nil
+}
+
+func parseTimeout(val string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(val)
+	if err != nil {
+		seconds, floatErr := strconv.ParseFloat(val, 64)
+		if floatErr != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, errors.New("timeout is not positive")
+	}
+	return timeout, nil
+}
@@ -0,0 +1,34 @@
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestDeadlineIsExceeded(t *testing.T) {
+	opts, err := NewOptions()
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+	// the deadline set by the gateway from Grpc-Timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, _, err = InterceptUnaryRequest(ctx, opts, "/example.AuthService/Auth", httptest.NewRequest(http.MethodGet, "/v1/example", nil), nil,
+		func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+			<-ctx.Done()
+			return nil, gwruntime.ServerMetadata{}, ctx.Err()
+		},
+	)
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Errorf("code is %s, want %s", code, codes.DeadlineExceeded)
+	}
+}
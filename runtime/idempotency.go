@@ -0,0 +1,286 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// IdempotencyKeyHeader is the header with the key of the request which is safe to retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// DefaultIdempotencyTTL is the time the responses are kept for unless WithIdempotencyTTL is set.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength  = 255
+	idempotencySweepInterval = time.Minute
+)
+
+// IdempotencyRecord is the request with an idempotency key kept by IdempotencyStore.
+type IdempotencyRecord struct {
+	// RequestHash is the hash of the scope of the key, the HTTP method, the URI and the body of the request.
+	RequestHash []byte
+	// Completed reports whether the response is saved, it is false while the first request is in progress.
+	Completed bool
+	// Response is the response of the first request.
+	Response proto.Message
+	// Request is the decoded first request, it is passed to the audit records of the replayed requests.
+	// It is nil unless the call is audited.
+	Request proto.Message
+	// Metadata is the metadata of the first request.
+	Metadata gwruntime.ServerMetadata
+}
+
+// IdempotencyStore keeps the requests with idempotency keys. Keys are unique for the method and the scope
+// resolved by IdempotencyScopeFunc.
+type IdempotencyStore interface {
+	// Start saves record of the request with key unless the key is used already, in that case
+	// it returns the record of the previous request and false.
+	Start(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	// Complete saves the response of the request started with key.
+	Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Cancel removes the request started with key, so it may be retried.
+	Cancel(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore is the in-process IdempotencyStore, records are removed after their TTL.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]memoryIdempotencyRecord
+	lastSweep time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+var (
+	defaultIdempotencyStore     *MemoryIdempotencyStore
+	defaultIdempotencyStoreOnce sync.Once
+)
+
+// DefaultIdempotencyStore returns the MemoryIdempotencyStore used when no store is set with WithIdempotencyStore.
+func DefaultIdempotencyStore() *MemoryIdempotencyStore {
+	defaultIdempotencyStoreOnce.Do(func() {
+		defaultIdempotencyStore = NewMemoryIdempotencyStore()
+	})
+	return defaultIdempotencyStore
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]memoryIdempotencyRecord),
+	}
+}
+
+// Start implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Start(_ context.Context, key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	if prev, ok := s.records[key]; ok && now.Before(prev.expires) {
+		return prev.record, false, nil
+	}
+	s.records[key] = memoryIdempotencyRecord{record: record, expires: now.Add(ttl)}
+	return record, true, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = memoryIdempotencyRecord{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Cancel implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Cancel(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < idempotencySweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, record := range s.records {
+		if !now.Before(record.expires) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// WithIdempotencyStore sets the store of the requests with idempotency keys instead of DefaultIdempotencyStore.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(o *Options) error {
+		o.idempotencyStore = store
+		return nil
+	}
+}
+
+// IdempotencyScopeFunc returns the scope of the idempotency keys of the gateway call, the same key sent
+// by the callers of different scopes refers to different requests.
+// Errors which are not gRPC statuses are returned as codes.Unauthenticated.
+type IdempotencyScopeFunc func(ctx context.Context) (string, error)
+
+// DefaultIdempotencyScope scopes the keys to the principal returned by PrincipalFromContext, so the callers
+// of the methods with authorization rules do not get the responses of each other. The keys of the methods
+// without the rules are shared by all the callers unless another scope is set with WithIdempotencyScope.
+func DefaultIdempotencyScope(ctx context.Context) (string, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprint(principal), nil
+}
+
+// WithIdempotencyScope sets the function resolving the scope of the idempotency keys instead of
+// DefaultIdempotencyScope, e.g. the API key or the tenant of the call.
+func WithIdempotencyScope(scope IdempotencyScopeFunc) Option {
+	return func(o *Options) error {
+		o.idempotencyScope = scope
+		return nil
+	}
+}
+
+// WithIdempotencyTTL sets the time the responses are kept for instead of DefaultIdempotencyTTL.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(o *Options) error {
+		o.idempotencyTTL = ttl
+		return nil
+	}
+}
+
+// idempotency returns the interceptor replaying the response of the first successful gateway call
+// with the same Idempotency-Key header in the same scope. It runs after the authorization check, so the repeated calls
+// are checked by the other interceptors. Repeated calls with another body fail with codes.FailedPrecondition,
+// the ones made while the first call is in progress fail with codes.Aborted. Failed and panicked calls are not saved.
+func (o *Options) idempotency(fullMethod string) grpc.UnaryServerInterceptor {
+	store, ttl, resolveScope := o.idempotencyStore, o.idempotencyTTL, o.idempotencyScope
+	if store == nil {
+		store = DefaultIdempotencyStore()
+	}
+	if resolveScope == nil {
+		resolveScope = DefaultIdempotencyScope
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
+		httpReq, ok := req.(*http.Request)
+		if !ok || httpReq.Header.Get(IdempotencyKeyHeader) == "" {
+			return handler(ctx, req)
+		}
+		idempotencyKey := httpReq.Header.Get(IdempotencyKeyHeader)
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+		}
+		scope, err := resolveScope(ctx)
+		if err != nil {
+			return nil, toStatusError(err, codes.Unauthenticated)
+		}
+		hash, err := hashRequest(httpReq, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		key := fullMethod + " " + strconv.Quote(scope) + " " + idempotencyKey
+		record, started, err := store.Start(ctx, key, &IdempotencyRecord{RequestHash: hash}, ttl)
+		if err != nil {
+			return nil, toStatusError(err, codes.Unavailable)
+		}
+		if !started {
+			switch {
+			case !bytes.Equal(record.RequestHash, hash):
+				return nil, status.Errorf(codes.FailedPrecondition, "%s is used for another request", IdempotencyKeyHeader)
+			case !record.Completed:
+				return nil, status.Errorf(codes.Aborted, "request with the same %s is in progress", IdempotencyKeyHeader)
+			}
+			if record.Request != nil {
+				AuditRequest(ctx, record.Request)
+			}
+			return HandlerResponse{Resp: record.Response, MD: record.Metadata}, nil
+		}
+
+		// the key is released unless the response is saved, the panics of the handler included
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if cancelErr := store.Cancel(ctx, key); cancelErr != nil && err == nil {
+				err = toStatusError(cancelErr, codes.Unavailable)
+			}
+		}()
+
+		// the headers set deeper in the chain are saved with the response and passed on
+		var stream gwruntime.ServerTransportStream
+		resp, err := handler(grpc.NewContextWithServerTransportStream(ctx, &stream), req)
+		if outer := grpc.ServerTransportStreamFromContext(ctx); outer != nil {
+			_ = outer.SetHeader(stream.Header())
+			_ = outer.SetTrailer(stream.Trailer())
+		}
+		data, ok := resp.(HandlerResponse)
+		if err != nil || !ok {
+			return resp, err
+		}
+		saved := &IdempotencyRecord{
+			RequestHash: hash,
+			Completed:   true,
+			Response:    proto.Clone(data.Resp),
+			Metadata: gwruntime.ServerMetadata{
+				HeaderMD:  metadata.Join(data.MD.HeaderMD, stream.Header()),
+				TrailerMD: metadata.Join(data.MD.TrailerMD, stream.Trailer()),
+			},
+		}
+		if call, ok := ctx.Value(auditCallKey{}).(*auditCall); ok && call.request != nil {
+			saved.Request = proto.Clone(call.request)
+		}
+		if err = store.Complete(ctx, key, saved, ttl); err != nil {
+			return nil, toStatusError(err, codes.Unavailable)
+		}
+		completed = true
+		return resp, nil
+	}
+}
+
+// hashRequest returns the hash of scope, the method, the URI and the body of req, the body is restored to be decoded.
+// The scope is a part of the key as well, it is hashed for the stores which do not keep the keys as is.
+func hashRequest(req *http.Request, scope string) ([]byte, error) {
+	hash := sha256.New()
+	hash.Write([]byte(strconv.Quote(scope) + " " + req.Method + " " + req.URL.RequestURI() + "\n"))
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			// the request body limit replaces the error when it is exceeded
+			return nil, status.Errorf(codes.InvalidArgument, "reading request body: %v", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// headerAuthorizer takes the principal from the X-User header.
+type headerAuthorizer struct{}
+
+func (headerAuthorizer) Principal(ctx context.Context) (interface{}, error) {
+	if req, ok := HTTPRequestFromContext(ctx); ok && req.Header.Get("X-User") != "" {
+		return req.Header.Get("X-User"), nil
+	}
+	return nil, status.Error(codes.Unauthenticated, "no user")
+}
+
+func (headerAuthorizer) Authorize(context.Context, interface{}, *AuthRule) error {
+	return nil
+}
+
+func TestIdempotencyKeysAreScopedToPrincipal(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	opts, err := NewOptions(
+		WithMethodCatalog([]MethodInfo{{FullMethod: fullMethod, IdempotencyKey: true}}),
+		WithAuthRules([]AuthRule{{FullMethod: fullMethod}}),
+		WithAuthorizer(headerAuthorizer{}),
+		WithIdempotencyStore(NewMemoryIdempotencyStore()),
+	)
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	var calls int
+	call := func(user string) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/example", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		req.Header.Set("X-User", user)
+		resp, _, err := InterceptUnaryRequest(context.Background(), opts, fullMethod, req, nil,
+			func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				calls++
+				return wrapperspb.String(user), gwruntime.ServerMetadata{}, nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("call of %s failed: %v", user, err)
+		}
+		return resp.(*wrapperspb.StringValue).GetValue()
+	}
+
+	for _, tc := range []struct {
+		user  string
+		calls int
+	}{
+		{user: "alice", calls: 1},
+		// the response is replayed for the same caller
+		{user: "alice", calls: 1},
+		{user: "bob", calls: 2},
+	} {
+		if got := call(tc.user); got != tc.user {
+			t.Errorf("%s got the response of %s", tc.user, got)
+		}
+		if calls != tc.calls {
+			t.Errorf("handler is called %d times, want %d", calls, tc.calls)
+		}
+	}
+}
+
+func TestIdempotencyKeyIsReleasedOnPanic(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	opts, err := NewOptions(
+		WithMethodCatalog([]MethodInfo{{FullMethod: fullMethod, IdempotencyKey: true}}),
+		WithIdempotencyStore(NewMemoryIdempotencyStore()),
+	)
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	call := func(panics bool) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = status.Errorf(codes.Internal, "%v", recovered)
+			}
+		}()
+		req := httptest.NewRequest(http.MethodPost, "/v1/example", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		_, _, err = InterceptUnaryRequest(context.Background(), opts, fullMethod, req, nil,
+			func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				if panics {
+					panic("boom")
+				}
+				return wrapperspb.String("ok"), gwruntime.ServerMetadata{}, nil
+			},
+		)
+		return err
+	}
+
+	if code := status.Code(call(true)); code != codes.Internal {
+		t.Fatalf("code of panicked call is %s, want %s", code, codes.Internal)
+	}
+	if err := call(false); err != nil {
+		t.Errorf("retry failed: %v", err)
+	}
+}
+
+type auditRecords []*AuditRecord
+
+func (r *auditRecords) Audit(_ context.Context, record *AuditRecord) {
+	*r = append(*r, record)
+}
+
+func TestIdempotencyReplayIsAuditedWithRequest(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	var records auditRecords
+	opts, err := NewOptions(
+		WithMethodCatalog([]MethodInfo{{FullMethod: fullMethod, IdempotencyKey: true}}),
+		WithIdempotencyStore(NewMemoryIdempotencyStore()),
+		WithAuditSink(&records),
+	)
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/example", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		_, _, err = InterceptUnaryRequest(context.Background(), opts, fullMethod, req, nil,
+			func(ctx context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				AuditRequest(ctx, wrapperspb.String("request"))
+				return wrapperspb.String("ok"), gwruntime.ServerMetadata{}, nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d audit records, want 2", len(records))
+	}
+	for i, record := range records {
+		if string(record.Request) != `"request"` {
+			t.Errorf("request of record %d is %s, want %q", i, record.Request, `"request"`)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExpvarName is the name of the expvar map with the metrics collected by DefaultExpvarCollector.
+const ExpvarName = "pgi_gateway"
+
+// Collector receives the results of the gateway calls.
+type Collector interface {
+	Observe(fullMethod string, code codes.Code, duration time.Duration)
+}
+
+// ExpvarCollector publishes the number of calls, their total duration and the number of calls
+// by status codes for every method in an expvar map.
+type ExpvarCollector struct {
+	vars *expvar.Map
+	mu   sync.Mutex
+}
+
+type expvarMethod struct {
+	requests *expvar.Int
+	duration *expvar.Float
+	codes    *expvar.Map
+}
+
+var (
+	defaultExpvarCollector     *ExpvarCollector
+	defaultExpvarCollectorOnce sync.Once
+)
+
+// DefaultExpvarCollector returns the collector publishing the metrics as ExpvarName.
+func DefaultExpvarCollector() *ExpvarCollector {
+	defaultExpvarCollectorOnce.Do(func() {
+		defaultExpvarCollector = NewExpvarCollector(expvar.NewMap(ExpvarName))
+	})
+	return defaultExpvarCollector
+}
+
+// NewExpvarCollector returns the collector which adds the metrics of every method to vars
+// by the full method name.
+func NewExpvarCollector(vars *expvar.Map) *ExpvarCollector {
+	return &ExpvarCollector{vars: vars}
+}
+
+// Register adds zero metrics for the methods from catalog, so they are published before the first call.
+func (c *ExpvarCollector) Register(catalog []MethodInfo) {
+	for i := range catalog {
+		c.method(catalog[i].FullMethod)
+	}
+}
+
+// Observe implements Collector.
+func (c *ExpvarCollector) Observe(fullMethod string, code codes.Code, duration time.Duration) {
+	method := c.method(fullMethod)
+	method.requests.Add(1)
+	method.duration.Add(duration.Seconds())
+	method.codes.Add(code.String(), 1)
+}
+
+func (c *ExpvarCollector) method(fullMethod string) expvarMethod {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if vars, ok := c.vars.Get(fullMethod).(*expvar.Map); ok {
+		return expvarMethod{
+			requests: vars.Get("requests").(*expvar.Int),
+			duration: vars.Get("duration_seconds").(*expvar.Float),
+			codes:    vars.Get("codes").(*expvar.Map),
+		}
+	}
+	method := expvarMethod{
+		requests: new(expvar.Int),
+		duration: new(expvar.Float),
+		codes:    new(expvar.Map).Init(),
+	}
+	vars := new(expvar.Map).Init()
+	vars.Set("requests", method.requests)
+	vars.Set("duration_seconds", method.duration)
+	vars.Set("codes", method.codes)
+	c.vars.Set(fullMethod, vars)
+	return method
+}
+
+// WithCollector adds a collector receiving the results of the gateway calls.
+func WithCollector(collector Collector) Option {
+	return func(o *Options) error {
+		o.collectors = append(o.collectors, collector)
+		return nil
+	}
+}
+
+// WithMetrics publishes the metrics of the methods from catalog with DefaultExpvarCollector.
+// It is used by the code generated with metrics=true.
+func WithMetrics(catalog []MethodInfo) Option {
+	return func(o *Options) error {
+		collector := DefaultExpvarCollector()
+		collector.Register(catalog)
+		for i := range o.collectors {
+			if o.collectors[i] == Collector(collector) {
+				return nil
+			}
+		}
+		o.collectors = append(o.collectors, collector)
+		return nil
+	}
+}
+
+// observe returns the outermost interceptor reporting the gateway calls to the collectors.
+// Calls of the gRPC server are not reported.
+func (o *Options) observe(fullMethod string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !IsGatewayCall(ctx) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		code := resolveCode(err)
+		for i := range o.collectors {
+			o.collectors[i].Observe(fullMethod, code, time.Since(start))
+		}
+		return resp, err
+	}
+}
+
+func resolveCode(err error) codes.Code {
+	var httpStatusErr *gwruntime.HTTPStatusError
+	if errors.As(err, &httpStatusErr) {
+		err = httpStatusErr.Err
+	}
+	return status.Code(err)
+}
@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestExpvarCollectorObservesGatewayCalls(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	vars := new(expvar.Map).Init()
+	opts, err := NewOptions(WithCollector(NewExpvarCollector(vars)))
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	for _, callErr := range []error{nil, status.Error(codes.NotFound, "not found")} {
+		callErr := callErr
+		_, _, err = InterceptUnaryRequest(context.Background(), opts, fullMethod, httptest.NewRequest(http.MethodGet, "/v1/example", nil), nil,
+			func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+				time.Sleep(time.Millisecond)
+				return &emptypb.Empty{}, gwruntime.ServerMetadata{}, callErr
+			},
+		)
+		if err != callErr {
+			t.Fatalf("call error is %v, want %v", err, callErr)
+		}
+	}
+	// the calls of the gRPC server are not observed
+	_, err = opts.UnaryServerInterceptor(fullMethod, nil)(context.Background(), &emptypb.Empty{}, &grpc.UnaryServerInfo{FullMethod: fullMethod},
+		func(context.Context, interface{}) (interface{}, error) {
+			return &emptypb.Empty{}, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("server call error is %v", err)
+	}
+
+	method, ok := vars.Get(fullMethod).(*expvar.Map)
+	if !ok {
+		t.Fatalf("metrics of %s are not published", fullMethod)
+	}
+	if got := method.Get("requests").(*expvar.Int).Value(); got != 2 {
+		t.Errorf("requests is %d, want 2", got)
+	}
+	if got := method.Get("duration_seconds").(*expvar.Float).Value(); got < 2*time.Millisecond.Seconds() {
+		t.Errorf("duration_seconds is %v, want at least %v", got, 2*time.Millisecond.Seconds())
+	}
+	methodCodes := method.Get("codes").(*expvar.Map)
+	for _, code := range []codes.Code{codes.OK, codes.NotFound} {
+		if got, ok := methodCodes.Get(code.String()).(*expvar.Int); !ok || got.Value() != 1 {
+			t.Errorf("codes[%s] is %v, want 1", code, methodCodes.Get(code.String()))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package runtime contains helpers used by the code generated with protoc-gen-interceptors.
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Option configures handlers registered by the generated Register*Handler* functions.
+type Option func(*Options) error
+
+// Options holds the configuration shared by handlers of a single registration.
+type Options struct {
+	trustedProxies []*net.IPNet
+
+	unaryClientInterceptors []grpc.UnaryClientInterceptor
+	unaryClientInterceptor  grpc.UnaryClientInterceptor
+
+	unaryServerInterceptors []grpc.UnaryServerInterceptor
+	unaryServerInterceptor  grpc.UnaryServerInterceptor
+	namedInterceptors       map[string]grpc.UnaryServerInterceptor
+	methodInterceptors      map[string][]grpc.UnaryServerInterceptor
+	methodInterceptor       map[string]grpc.UnaryServerInterceptor
+
+	dialOptions              []grpc.DialOption
+	clientConn               *grpc.ClientConn
+	clientInterceptorsDialed bool
+
+	recover      bool
+	panicHandler PanicHandler
+
+	authorizer Authorizer
+	authRules  map[string]*AuthRule
+
+	methods        map[string]*MethodInfo
+	timeoutHeader  string
+	defaultTimeout time.Duration
+
+	collectors []Collector
+	auditSinks []AuditSink
+
+	limiter Limiter
+
+	idempotencyStore IdempotencyStore
+	idempotencyTTL   time.Duration
+	idempotencyScope IdempotencyScopeFunc
+
+	tracing TracingFunc
+}
+
+// NewOptions builds Options from the given list of Option.
+func NewOptions(opts ...Option) (*Options, error) {
+	resp := &Options{}
+	for i := range opts {
+		if opts[i] == nil {
+			continue
+		}
+		if err := opts[i](resp); err != nil {
+			return nil, err
+		}
+	}
+	if len(resp.authRules) != 0 && resp.authorizer == nil {
+		return nil, errors.New("methods with authorization rules require WithAuthorizer")
+	}
+	resp.unaryClientInterceptor = chainUnaryClientInterceptors(resp.unaryClientInterceptors)
+	resp.unaryServerInterceptor = chainUnaryServerInterceptors(resp.unaryServerInterceptors)
+	resp.methodInterceptor = make(map[string]grpc.UnaryServerInterceptor, len(resp.methodInterceptors))
+	for fullMethod, interceptors := range resp.methodInterceptors {
+		resp.methodInterceptor[fullMethod] = chainUnaryServerInterceptors(interceptors)
+	}
+	return resp, nil
+}
+
+// WithTrustedProxies enables X-Forwarded-For handling for requests coming from the given
+// addresses. Each item is either a single IP or a CIDR.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(o *Options) error {
+		for _, val := range proxies {
+			if !strings.Contains(val, "/") {
+				ip := net.ParseIP(val)
+				if ip == nil {
+					return fmt.Errorf("invalid trusted proxy address %q", val)
+				}
+				bits := 8 * net.IPv4len
+				if ip.To4() == nil {
+					bits = 8 * net.IPv6len
+				}
+				o.trustedProxies = append(o.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(val)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy network %q: %w", val, err)
+			}
+			o.trustedProxies = append(o.trustedProxies, ipNet)
+		}
+		return nil
+	}
+}
+
+func (o *Options) isTrustedProxy(ip net.IP) bool {
+	if o == nil || ip == nil {
+		return false
+	}
+	for i := range o.trustedProxies {
+		if o.trustedProxies[i].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+// Package otelpgi traces the gateway calls of github.com/tarmalonchik/protoc-gen-interceptors/runtime
+// with OpenTelemetry. The code generated with tracing=true adds WithTracing to the options of the services.
+package otelpgi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	pgiruntime "github.com/tarmalonchik/protoc-gen-interceptors/runtime"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/tarmalonchik/protoc-gen-interceptors/runtime/otelpgi"
+
+// defaultPropagator extracts the W3C trace context and baggage when the global propagator is not set,
+// as the global one does nothing by default.
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Option configures WithTracing.
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// WithTracerProvider sets the tracer provider instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithPropagator sets the propagator extracting the trace context from the HTTP headers instead of the global one.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+// WithTracing starts a server span for every gateway call with the global tracer provider and propagator,
+// unless they are set with WithTracerProvider and WithPropagator. The W3C trace context and baggage
+// are extracted when the global propagator is not set. The options passed to the Register* functions
+// go after the generated ones, so WithTracing passed there replaces the generated one.
+func WithTracing(opts ...Option) pgiruntime.Option {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return pgiruntime.WithTracingFunc(c.trace)
+}
+
+// trace returns the interceptor starting the server span of the gateway call named after fullMethod.
+// The trace context is extracted from the HTTP headers unless the context has a span already,
+// e.g. started by an HTTP middleware. Calls of the gRPC server are not traced.
+func (c *config) trace(fullMethod string) grpc.UnaryServerInterceptor {
+	provider := c.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	tracer := provider.Tracer(instrumentationName)
+	fullService, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		httpReq, ok := pgiruntime.HTTPRequestFromContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			propagator := c.propagator
+			if propagator == nil {
+				propagator = otel.GetTextMapPropagator()
+				if len(propagator.Fields()) == 0 {
+					propagator = defaultPropagator
+				}
+			}
+			ctx = propagator.Extract(ctx, propagation.HeaderCarrier(httpReq.Header))
+		}
+
+		attrs := []attribute.KeyValue{
+			semconv.RPCSystemGRPC,
+			semconv.RPCServiceKey.String(fullService),
+			semconv.RPCMethodKey.String(method),
+			semconv.HTTPMethodKey.String(httpReq.Method),
+		}
+		if pattern, ok := pgiruntime.HTTPPatternFromContext(ctx); ok {
+			attrs = append(attrs, semconv.HTTPRouteKey.String(pattern))
+		}
+		ctx, span := tracer.Start(
+			ctx,
+			strings.TrimPrefix(fullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		span.SetAttributes(
+			semconv.RPCGRPCStatusCodeKey.Int(int(resolveCode(err))),
+			semconv.HTTPStatusCodeKey.Int(resolveHTTPStatus(err)),
+		)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+		}
+		return resp, err
+	}
+}
+
+// resolveCode returns the code of err, the errors with HTTP statuses wrap the status errors.
+func resolveCode(err error) codes.Code {
+	var httpStatusErr *gwruntime.HTTPStatusError
+	if errors.As(err, &httpStatusErr) {
+		err = httpStatusErr.Err
+	}
+	return status.Code(err)
+}
+
+func resolveHTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var httpStatusErr *gwruntime.HTTPStatusError
+	if errors.As(err, &httpStatusErr) {
+		return httpStatusErr.HTTPStatus
+	}
+	return gwruntime.HTTPStatusFromCode(resolveCode(err))
+}
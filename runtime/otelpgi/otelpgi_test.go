@@ -0,0 +1,112 @@
+package otelpgi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	pgiruntime "github.com/tarmalonchik/protoc-gen-interceptors/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestTraceGatewayCall(t *testing.T) {
+	const (
+		fullMethod  = "/example.AuthService/Auth"
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID    = "00f067aa0ba902b7"
+		traceparent = "00-" + traceID + "-" + parentID + "-01"
+	)
+	for _, tc := range []struct {
+		name       string
+		err        error
+		grpcCode   codes.Code
+		httpStatus int
+		spanStatus otelcodes.Code
+	}{
+		{name: "success", grpcCode: codes.OK, httpStatus: http.StatusOK, spanStatus: otelcodes.Unset},
+		{
+			name:       "error",
+			err:        status.Error(codes.NotFound, "not found"),
+			grpcCode:   codes.NotFound,
+			httpStatus: http.StatusNotFound,
+			spanStatus: otelcodes.Error,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			opts, err := pgiruntime.NewOptions(WithTracing(WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))))
+			if err != nil {
+				t.Fatalf("creating options: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+			// the global propagator is not set, so the trace context is extracted by default
+			req.Header.Set("traceparent", traceparent)
+			ctx, err := gwruntime.AnnotateIncomingContext(context.Background(), gwruntime.NewServeMux(), req, fullMethod,
+				gwruntime.WithHTTPPathPattern("/v1/example"))
+			if err != nil {
+				t.Fatalf("annotating context: %v", err)
+			}
+
+			_, _, err = pgiruntime.InterceptUnaryRequest(ctx, opts, fullMethod, req, nil,
+				func(context.Context) (proto.Message, gwruntime.ServerMetadata, error) {
+					return &emptypb.Empty{}, gwruntime.ServerMetadata{}, tc.err
+				},
+			)
+			if err != tc.err {
+				t.Fatalf("call error is %v, want %v", err, tc.err)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("%d spans are ended, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != "example.AuthService/Auth" {
+				t.Errorf("span name is %q, want %q", span.Name(), "example.AuthService/Auth")
+			}
+			if span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("span kind is %s, want %s", span.SpanKind(), trace.SpanKindServer)
+			}
+			if got := span.Parent().TraceID().String(); got != traceID {
+				t.Errorf("parent trace id is %s, want %s", got, traceID)
+			}
+			if got := span.Parent().SpanID().String(); got != parentID {
+				t.Errorf("parent span id is %s, want %s", got, parentID)
+			}
+			if !span.Parent().IsRemote() {
+				t.Error("parent span is not remote")
+			}
+			if span.Status().Code != tc.spanStatus {
+				t.Errorf("span status is %s, want %s", span.Status().Code, tc.spanStatus)
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, attr := range span.Attributes() {
+				attrs[attr.Key] = attr.Value
+			}
+			for key, want := range map[attribute.Key]attribute.Value{
+				"rpc.system":           attribute.StringValue("grpc"),
+				"rpc.service":          attribute.StringValue("example.AuthService"),
+				"rpc.method":           attribute.StringValue("Auth"),
+				"http.method":          attribute.StringValue(http.MethodGet),
+				"http.route":           attribute.StringValue("/v1/example"),
+				"rpc.grpc.status_code": attribute.IntValue(int(tc.grpcCode)),
+				"http.status_code":     attribute.IntValue(tc.httpStatus),
+			} {
+				if got := attrs[key]; got != want {
+					t.Errorf("attribute %s is %v, want %v", key, got.Emit(), want.Emit())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// httpAddr is used when the remote address of the request can't be parsed as host:port.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+
+func (a httpAddr) String() string { return string(a) }
+
+// NewPeerContext returns a copy of ctx carrying a peer.Peer built from req, so that
+// peer.FromContext and credentials.TLSInfo work in interceptors the same way they do for gRPC calls.
+// X-Forwarded-For is taken into account only when the request came from a trusted proxy.
+func NewPeerContext(ctx context.Context, req *http.Request, opts *Options) context.Context {
+	if req == nil {
+		return ctx
+	}
+	p := &peer.Peer{
+		Addr: resolveRemoteAddr(req, opts),
+	}
+	if req.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{
+			State: *req.TLS,
+			CommonAuthInfo: credentials.CommonAuthInfo{
+				SecurityLevel: credentials.PrivacyAndIntegrity,
+			},
+		}
+	}
+	return peer.NewContext(ctx, p)
+}
+
+func resolveRemoteAddr(req *http.Request, opts *Options) net.Addr {
+	host, port, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return httpAddr(req.RemoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return httpAddr(req.RemoteAddr)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	addr := &net.TCPAddr{IP: ip, Port: portNumber}
+
+	if !opts.isTrustedProxy(ip) {
+		return addr
+	}
+
+	// walking from the right, the first address which is not a trusted proxy is the client
+	forwarded := strings.Split(strings.Join(req.Header.Values(forwardedForHeader), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+		addr = &net.TCPAddr{IP: forwardedIP}
+		if !opts.isTrustedProxy(forwardedIP) {
+			break
+		}
+	}
+	return addr
+}
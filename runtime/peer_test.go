@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestNewPeerContextAddr(t *testing.T) {
+	opts, err := NewOptions(WithTrustedProxies("10.0.0.1", "192.168.0.0/16"))
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		opts       *Options
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:4321", want: "203.0.113.7:4321"},
+		{name: "untrusted proxy", remoteAddr: "203.0.113.7:4321", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7:4321"},
+		{name: "without trusted proxies", remoteAddr: "10.0.0.1:4321", forwarded: []string{"198.51.100.1"}, opts: &Options{}, want: "10.0.0.1:4321"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:4321", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1:0"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:4321", want: "10.0.0.1:4321"},
+		{
+			// the client may put anything in front of the address added by the proxy
+			name:       "spoofed first hop",
+			remoteAddr: "10.0.0.1:4321",
+			forwarded:  []string{"1.1.1.1, 198.51.100.1"},
+			want:       "198.51.100.1:0",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4321",
+			forwarded:  []string{"198.51.100.1,192.168.1.1", "192.168.1.2"},
+			want:       "198.51.100.1:0",
+		},
+		{
+			// the first hop is the client when all the others are trusted
+			name:       "all trusted",
+			remoteAddr: "10.0.0.1:4321",
+			forwarded:  []string{" 192.168.1.1 ,  192.168.1.2"},
+			want:       "192.168.1.1:0",
+		},
+		{name: "ipv6", remoteAddr: "10.0.0.1:4321", forwarded: []string{"2001:db8::1"}, want: "[2001:db8::1]:0"},
+		{
+			// the walk stops at the invalid entry, the last valid address is used
+			name:       "invalid entry",
+			remoteAddr: "10.0.0.1:4321",
+			forwarded:  []string{"198.51.100.1, unknown, 192.168.1.1"},
+			want:       "192.168.1.1:0",
+		},
+		{name: "invalid last entry", remoteAddr: "10.0.0.1:4321", forwarded: []string{"198.51.100.1, "}, want: "10.0.0.1:4321"},
+		{name: "unparsable remote address", remoteAddr: "pipe", forwarded: []string{"198.51.100.1"}, want: "pipe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, val := range tc.forwarded {
+				req.Header.Add(forwardedForHeader, val)
+			}
+			reqOpts := opts
+			if tc.opts != nil {
+				reqOpts = tc.opts
+			}
+			p, ok := peer.FromContext(NewPeerContext(context.Background(), req, reqOpts))
+			if !ok {
+				t.Fatal("no peer in context")
+			}
+			if got := p.Addr.String(); got != tc.want {
+				t.Errorf("address is %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewPeerContextTLS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+	p, _ := peer.FromContext(NewPeerContext(context.Background(), req, nil))
+	if p.AuthInfo != nil {
+		t.Errorf("auth info of plain request is %v, want none", p.AuthInfo)
+	}
+
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, ServerName: "example.com", HandshakeComplete: true}
+	p, _ = peer.FromContext(NewPeerContext(context.Background(), req, nil))
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		t.Fatalf("auth info is %T, want credentials.TLSInfo", p.AuthInfo)
+	}
+	if info.AuthType() != "tls" || info.State.ServerName != "example.com" || info.State.Version != tls.VersionTLS13 {
+		t.Errorf("TLS state is %+v", info.State)
+	}
+	if info.SecurityLevel != credentials.PrivacyAndIntegrity {
+		t.Errorf("security level is %v, want %v", info.SecurityLevel, credentials.PrivacyAndIntegrity)
+	}
+}
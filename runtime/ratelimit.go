@@ -0,0 +1,229 @@
+package runtime
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// RetryAfterHeader is the header with the number of seconds to wait after the call was rate limited.
+	RetryAfterHeader = "Retry-After"
+
+	rateLimitPeerKey         = "peer"
+	rateLimitHeaderKeyPrefix = "header:"
+
+	limiterSweepInterval = time.Minute
+)
+
+// RateLimit is the rate limit declared for a method with the (pgi.rate_limit) proto option.
+type RateLimit struct {
+	// RPS is the rate of the calls per second.
+	RPS float64
+	// Burst is the size of the bucket, it defaults to RPS rounded up.
+	Burst int
+	// Key selects the client: "peer" for the remote address, "header:<name>" for the HTTP header
+	// on the gateway and the metadata on the gRPC server. The calls share one bucket when it is empty.
+	Key string
+}
+
+// Limiter decides whether the call is allowed by the rate limit of the method.
+type Limiter interface {
+	// Allow takes a token from the bucket of key for the method. When the call is not allowed
+	// it returns the time after which it may be retried. Errors which are not gRPC statuses
+	// are returned as codes.Unavailable.
+	Allow(ctx context.Context, fullMethod, key string, limit *RateLimit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// MemoryLimiter is the in-process Limiter with a token bucket per method and key.
+// Buckets which are full again are removed.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[limiterBucketKey]*limiterBucket
+	lastSweep time.Time
+}
+
+type limiterBucketKey struct {
+	fullMethod string
+	key        string
+}
+
+type limiterBucket struct {
+	tokens  float64
+	updated time.Time
+	rps     float64
+	burst   float64
+}
+
+var (
+	defaultLimiter     *MemoryLimiter
+	defaultLimiterOnce sync.Once
+)
+
+// DefaultLimiter returns the MemoryLimiter used when no limiter is set with WithLimiter,
+// it is shared by all registrations, so the gateway and the gRPC server have common buckets.
+func DefaultLimiter() *MemoryLimiter {
+	defaultLimiterOnce.Do(func() {
+		defaultLimiter = NewMemoryLimiter()
+	})
+	return defaultLimiter
+}
+
+// NewMemoryLimiter returns an empty MemoryLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[limiterBucketKey]*limiterBucket),
+	}
+}
+
+// Allow implements Limiter.
+func (l *MemoryLimiter) Allow(_ context.Context, fullMethod, key string, limit *RateLimit) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucketKey := limiterBucketKey{fullMethod: fullMethod, key: key}
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		burst := float64(limit.Burst)
+		if burst <= 0 {
+			burst = math.Ceil(limit.RPS)
+		}
+		bucket = &limiterBucket{tokens: burst, updated: now, rps: limit.RPS, burst: burst}
+		l.buckets[bucketKey] = bucket
+	}
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - bucket.tokens) / bucket.rps * float64(time.Second)), nil
+}
+
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.refill(now); bucket.tokens >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *limiterBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rps)
+		b.updated = now
+	}
+}
+
+// WithLimiter sets the limiter checking the methods with rate limits instead of DefaultLimiter.
+func WithLimiter(limiter Limiter) Option {
+	return func(o *Options) error {
+		o.limiter = limiter
+		return nil
+	}
+}
+
+// OutgoingHeaderMatcher drops Retry-After metadata of the rate limited calls, which is set as the HTTP header
+// by SetRetryAfterHeader already, so it is not duplicated as Grpc-Metadata-Retry-After. Other metadata
+// is prefixed the same way as by the default matcher of grpc-gateway. It is used with runtime.WithOutgoingHeaderMatcher.
+func OutgoingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, RetryAfterHeader) {
+		return "", false
+	}
+	return gwruntime.MetadataHeaderPrefix + key, true
+}
+
+// SetRetryAfterHeader sets the Retry-After header of the response from errdetails.RetryInfo
+// of the rate limited call. It is called by the generated handlers of the methods with (pgi.rate_limit).
+func SetRetryAfterHeader(w http.ResponseWriter, err error) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			seconds := int64(math.Ceil(info.GetRetryDelay().AsDuration().Seconds()))
+			w.Header().Set(RetryAfterHeader, strconv.FormatInt(seconds, 10))
+			return
+		}
+	}
+}
+
+// rateLimit returns the interceptor taking a token of the client for fullMethod,
+// the rate limited calls fail with codes.ResourceExhausted and errdetails.RetryInfo.
+// The Retry-After header is set with grpc.SetHeader for the gRPC server and by SetRetryAfterHeader for the gateway.
+func (o *Options) rateLimit(fullMethod string, limit *RateLimit) grpc.UnaryServerInterceptor {
+	limiter := o.limiter
+	if limiter == nil {
+		limiter = DefaultLimiter()
+	}
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		allowed, retryAfter, err := limiter.Allow(ctx, fullMethod, resolveRateLimitKey(ctx, limit.Key), limit)
+		if err != nil {
+			return nil, toStatusError(err, codes.Unavailable)
+		}
+		if !allowed {
+			return nil, rateLimitedError(ctx, fullMethod, retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func resolveRateLimitKey(ctx context.Context, key string) string {
+	switch {
+	case key == rateLimitPeerKey:
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	case strings.HasPrefix(key, rateLimitHeaderKeyPrefix):
+		name := strings.TrimPrefix(key, rateLimitHeaderKeyPrefix)
+		if req, ok := HTTPRequestFromContext(ctx); ok {
+			return req.Header.Get(name)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(name); len(values) != 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func rateLimitedError(ctx context.Context, fullMethod string, retryAfter time.Duration) error {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	// the header is lost if the transport stream is missing, the delay is in the details as well
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.FormatInt(seconds, 10)))
+
+	st := status.Newf(codes.ResourceExhausted, "rate limit of %s is exceeded", fullMethod)
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(seconds) * time.Second)}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
@@ -0,0 +1,33 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSetRetryAfterHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "rate limited", err: rateLimitedError(context.Background(), "/example.AuthService/Auth", 1500*time.Millisecond), want: "2"},
+		{name: "no details", err: status.Error(codes.ResourceExhausted, "too large")},
+		{name: "other code", err: status.Error(codes.Unavailable, "unavailable")},
+		{name: "not status", err: errors.New("failed")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			SetRetryAfterHeader(rec, tc.err)
+			if got := rec.Header().Get(RetryAfterHeader); got != tc.want {
+				t.Errorf("%s is %q, want %q", RetryAfterHeader, got, tc.want)
+			}
+		})
+	}
+}
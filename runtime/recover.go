@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"context"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PanicHandler is called with the value recovered from a panic and the stack trace of the panic.
+type PanicHandler func(ctx context.Context, recovered interface{}, stack []byte)
+
+// WithRecover turns panics of the gateway calls into codes.Internal errors. It is used by the code
+// generated with recover=true.
+func WithRecover() Option {
+	return func(o *Options) error {
+		o.recover = true
+		return nil
+	}
+}
+
+// WithPanicHandler sets the handler called when a gateway call panics with WithRecover.
+func WithPanicHandler(handler PanicHandler) Option {
+	return func(o *Options) error {
+		o.panicHandler = handler
+		return nil
+	}
+}
+
+// RecoverPanic reports the recovered value to the handler set with WithPanicHandler
+// and returns the codes.Internal error rendered to the client instead of the panic.
+// It has to be called by the deferred function which recovered the panic.
+func RecoverPanic(ctx context.Context, opts *Options, recovered interface{}) error {
+	if opts != nil && opts.panicHandler != nil {
+		opts.panicHandler(ctx, recovered, debug.Stack())
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+// recoverPanics returns the interceptor recovering the panics of the gateway calls. It wraps both the interceptors
+// after the audit and the handler, so the interceptors see the panics of the handler as errors.
+func (o *Options) recoverPanics() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !IsGatewayCall(ctx) {
+			return handler(ctx, req)
+		}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				resp, err = nil, RecoverPanic(ctx, o, recovered)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
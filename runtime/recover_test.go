@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	var (
+		recovered interface{}
+		stack     []byte
+	)
+	opts, err := NewOptions(WithPanicHandler(func(_ context.Context, val interface{}, trace []byte) {
+		recovered, stack = val, trace
+	}))
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+
+	err = RecoverPanic(context.Background(), opts, "boom")
+	if code := status.Code(err); code != codes.Internal {
+		t.Errorf("code is %s, want %s", code, codes.Internal)
+	}
+	if recovered != "boom" {
+		t.Errorf("panic handler got %v, want %q", recovered, "boom")
+	}
+	if len(stack) == 0 {
+		t.Error("panic handler got no stack trace")
+	}
+	// the handler is optional
+	if code := status.Code(RecoverPanic(context.Background(), nil, "boom")); code != codes.Internal {
+		t.Errorf("code without options is %s, want %s", code, codes.Internal)
+	}
+}
+
+func TestRecoverPanicsOfChain(t *testing.T) {
+	const fullMethod = "/example.AuthService/Auth"
+	var observed []codes.Code
+	opts, err := NewOptions(
+		WithRecover(),
+		WithCollector(collectorFunc(func(_ string, code codes.Code) {
+			observed = append(observed, code)
+		})),
+		WithUnaryServerInterceptors(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if req.(*http.Request).Header.Get("X-Panic") == "interceptor" {
+				panic("interceptor")
+			}
+			return handler(ctx, req)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("creating options: %v", err)
+	}
+	panicking := func(context.Context, interface{}) (interface{}, error) {
+		panic("handler")
+	}
+
+	for _, where := range []string{"interceptor", "handler"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+		req.Header.Set("X-Panic", where)
+		ctx := NewGatewayContext(context.Background(), fullMethod, req, nil, opts)
+		_, err = opts.UnaryServerInterceptor(fullMethod, nil)(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, panicking)
+		if code := status.Code(err); code != codes.Internal {
+			t.Errorf("code of %s panic is %s, want %s", where, code, codes.Internal)
+		}
+	}
+	// the metrics see both panics as errors
+	if len(observed) != 2 || observed[0] != codes.Internal || observed[1] != codes.Internal {
+		t.Errorf("observed codes are %v, want two %s", observed, codes.Internal)
+	}
+}
+
+type collectorFunc func(fullMethod string, code codes.Code)
+
+func (f collectorFunc) Observe(fullMethod string, code codes.Code, _ time.Duration) {
+	f(fullMethod, code)
+}
@@ -0,0 +1,297 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// HandlerResponse is returned to the interceptors by the handlers of the gateway calls,
+// so they are able to access the response and the metadata of the call.
+type HandlerResponse struct {
+	Resp proto.Message
+	MD   gwruntime.ServerMetadata
+}
+
+// WithUnaryServerInterceptors adds interceptors executed by the gateway before the request is dispatched.
+// See Options.UnaryServerInterceptor for their place in the chain.
+// The first interceptor is the outermost one.
+func WithUnaryServerInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *Options) error {
+		o.unaryServerInterceptors = append(o.unaryServerInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithNamedInterceptor registers an interceptor selected by name with the
+// (pgi.interceptors) and (pgi.service_interceptors) proto options.
+func WithNamedInterceptor(name string, interceptor grpc.UnaryServerInterceptor) Option {
+	return func(o *Options) error {
+		if interceptor == nil {
+			return fmt.Errorf("named interceptor %q is nil", name)
+		}
+		if _, ok := o.namedInterceptors[name]; ok {
+			return fmt.Errorf("named interceptor %q is registered twice", name)
+		}
+		if o.namedInterceptors == nil {
+			o.namedInterceptors = make(map[string]grpc.UnaryServerInterceptor)
+		}
+		o.namedInterceptors[name] = interceptor
+		return nil
+	}
+}
+
+// CheckNamedInterceptors returns an error if any of names is not registered with WithNamedInterceptor.
+func (o *Options) CheckNamedInterceptors(names ...string) error {
+	for _, name := range names {
+		if o == nil || o.namedInterceptors[name] == nil {
+			return fmt.Errorf("named interceptor %q is not registered", name)
+		}
+	}
+	return nil
+}
+
+// WithMethodInterceptors adds interceptors executed only for the given full method names,
+// e.g. "/example.AuthService/Auth". Register* functions reject names of unknown methods.
+func WithMethodInterceptors(interceptors map[string][]grpc.UnaryServerInterceptor) Option {
+	return func(o *Options) error {
+		if o.methodInterceptors == nil {
+			o.methodInterceptors = make(map[string][]grpc.UnaryServerInterceptor, len(interceptors))
+		}
+		for fullMethod, list := range interceptors {
+			if _, _, ok := splitFullMethod(fullMethod); !ok {
+				return fmt.Errorf("invalid full method name %q", fullMethod)
+			}
+			o.methodInterceptors[fullMethod] = append(o.methodInterceptors[fullMethod], list...)
+		}
+		return nil
+	}
+}
+
+// CheckMethodInterceptors returns an error if interceptors were added with WithMethodInterceptors
+// for a method of service which is not one of methods. The same options may be used to register
+// several services, so methods of other services are only checked to be declared in the proto files
+// linked into the binary.
+func (o *Options) CheckMethodInterceptors(service string, methods ...string) error {
+	if o == nil {
+		return nil
+	}
+	for fullMethod := range o.methodInterceptors {
+		methodService, method, _ := splitFullMethod(fullMethod)
+		if methodService == service {
+			if containsString(methods, method) {
+				continue
+			}
+			return fmt.Errorf("interceptors are added for unknown method %q of service %s", fullMethod, service)
+		}
+		if !isKnownMethod(methodService, method) {
+			return fmt.Errorf("interceptors are added for unknown method %q", fullMethod)
+		}
+	}
+	return nil
+}
+
+func isKnownMethod(service, method string) bool {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return false
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	return ok && serviceDesc.Methods().ByName(protoreflect.Name(method)) != nil
+}
+
+func splitFullMethod(fullMethod string) (service, method string, ok bool) {
+	if !strings.HasPrefix(fullMethod, "/") {
+		return "", "", false
+	}
+	service, method, ok = strings.Cut(fullMethod[1:], "/")
+	return service, method, ok && service != "" && method != "" && !strings.Contains(method, "/")
+}
+
+func containsString(in []string, val string) bool {
+	for i := range in {
+		if in[i] == val {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor returns the chain of the tracing, the metrics, the audit, the panic recovery, the request body
+// limit, the deadline setup, the rate limit, first, the interceptors from options, the named interceptors, the interceptors
+// added for fullMethod, the authorization check and the idempotency key check, in that order. The panics of the handler
+// are recovered right around it as well, so every interceptor sees them as codes.Internal errors.
+// first is the interceptor configured for the whole grpc.Server on the RegisterService path and the one passed
+// to the Register*HandlerServer functions on the gateway path. The named interceptors of the service go before
+// the ones of the method. The tracing, the metrics, the audit, the panic recovery and the request body limit
+// only run for the gateway calls.
+// Nil is returned when there is nothing to call, i.e. for nil options without first.
+func (o *Options) UnaryServerInterceptor(fullMethod string, first *grpc.UnaryServerInterceptor, names ...string) grpc.UnaryServerInterceptor {
+	var interceptors []grpc.UnaryServerInterceptor
+	if o != nil && o.tracing != nil {
+		if interceptor := o.tracing(fullMethod); interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+	}
+	if o != nil && len(o.collectors) != 0 {
+		interceptors = append(interceptors, o.observe(fullMethod))
+	}
+	if o != nil && len(o.auditSinks) != 0 {
+		interceptors = append(interceptors, o.audit(fullMethod))
+	}
+	if o != nil && o.recover {
+		interceptors = append(interceptors, o.recoverPanics())
+	}
+	if info := o.methodInfo(fullMethod); info != nil && info.MaxRequestBytes > 0 {
+		interceptors = append(interceptors, limitRequestBody(info.MaxRequestBytes))
+	}
+	if o != nil {
+		interceptors = append(interceptors, o.deadline(fullMethod))
+	}
+	if info := o.methodInfo(fullMethod); info != nil && info.RateLimit != nil {
+		interceptors = append(interceptors, o.rateLimit(fullMethod, info.RateLimit))
+	}
+	if first != nil && *first != nil {
+		interceptors = append(interceptors, *first)
+	}
+	if o != nil {
+		if o.unaryServerInterceptor != nil {
+			interceptors = append(interceptors, o.unaryServerInterceptor)
+		}
+		for _, name := range names {
+			if interceptor := o.namedInterceptors[name]; interceptor != nil {
+				interceptors = append(interceptors, interceptor)
+			}
+		}
+		if interceptor := o.methodInterceptor[fullMethod]; interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+		if rule := o.authRules[fullMethod]; rule != nil {
+			interceptors = append(interceptors, o.authorize(rule))
+		}
+		if info := o.methodInfo(fullMethod); info != nil && info.IdempotencyKey {
+			interceptors = append(interceptors, o.idempotency(fullMethod))
+		}
+		if o.recover {
+			interceptors = append(interceptors, o.recoverPanics())
+		}
+	}
+	if len(interceptors) == 1 {
+		return interceptors[0]
+	}
+	return chainUnaryServerInterceptors(interceptors)
+}
+
+// InterceptUnaryRequest runs the unary server interceptors from opts and the named ones around call,
+// which dispatches the HTTP request to the gRPC client. Metadata set by the interceptors with grpc.SetHeader
+// and grpc.SetTrailer is added to the returned one.
+func InterceptUnaryRequest(
+	ctx context.Context,
+	opts *Options,
+	fullMethod string,
+	req *http.Request,
+	pathParams map[string]string,
+	call func(context.Context) (proto.Message, gwruntime.ServerMetadata, error),
+	names ...string,
+) (proto.Message, gwruntime.ServerMetadata, error) {
+	ctx = NewGatewayContext(ctx, fullMethod, req, pathParams, opts)
+
+	interceptor := opts.UnaryServerInterceptor(fullMethod, nil, names...)
+	if interceptor == nil {
+		return call(ctx)
+	}
+
+	var stream gwruntime.ServerTransportStream
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &stream)
+
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		resp, md, err := call(ctx)
+		return HandlerResponse{Resp: resp, MD: md}, err
+	}
+
+	item, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+	data, _ := item.(HandlerResponse)
+	data.MD.HeaderMD = metadata.Join(data.MD.HeaderMD, stream.Header())
+	data.MD.TrailerMD = metadata.Join(data.MD.TrailerMD, stream.Trailer())
+	return data.Resp, data.MD, err
+}
+
+func chainUnaryServerInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return interceptors[0](ctx, req, info, getChainUnaryHandler(interceptors, 0, info, handler))
+	}
+}
+
+func getChainUnaryHandler(interceptors []grpc.UnaryServerInterceptor, curr int, info *grpc.UnaryServerInfo, finalHandler grpc.UnaryHandler) grpc.UnaryHandler {
+	if curr == len(interceptors)-1 {
+		return finalHandler
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptors[curr+1](ctx, req, info, getChainUnaryHandler(interceptors, curr+1, info, finalHandler))
+	}
+}
+
+// RegisterService registers impl on s with the unary server interceptors from opts,
+// so the gRPC server and the gateway registered with the same options behave the same way.
+// The interceptor configured for the whole grpc.Server is the first one of Options.UnaryServerInterceptor,
+// which describes the order of the chain.
+// Names of the interceptors selected with proto options are given by full method names.
+func RegisterService(
+	s grpc.ServiceRegistrar,
+	desc *grpc.ServiceDesc,
+	impl interface{},
+	names map[string][]string,
+	opts ...Option,
+) error {
+	options, err := NewOptions(opts...)
+	if err != nil {
+		return err
+	}
+	for _, methodNames := range names {
+		if err = options.CheckNamedInterceptors(methodNames...); err != nil {
+			return err
+		}
+	}
+	methods := make([]string, len(desc.Methods))
+	for i := range desc.Methods {
+		methods[i] = desc.Methods[i].MethodName
+	}
+	if err = options.CheckMethodInterceptors(desc.ServiceName, methods...); err != nil {
+		return err
+	}
+
+	interceptedDesc := *desc
+	interceptedDesc.Methods = make([]grpc.MethodDesc, len(desc.Methods))
+	for i := range desc.Methods {
+		fullMethod := fmt.Sprintf("/%s/%s", desc.ServiceName, desc.Methods[i].MethodName)
+		interceptedDesc.Methods[i] = grpc.MethodDesc{
+			MethodName: desc.Methods[i].MethodName,
+			Handler:    interceptMethodHandler(desc.Methods[i].Handler, options, fullMethod, names[fullMethod]),
+		}
+	}
+	s.RegisterService(&interceptedDesc, impl)
+	return nil
+}
+
+type methodHandler = func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+func interceptMethodHandler(handler methodHandler, options *Options, fullMethod string, names []string) methodHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		var first *grpc.UnaryServerInterceptor
+		if interceptor != nil {
+			first = &interceptor
+		}
+		return handler(srv, ctx, dec, options.UnaryServerInterceptor(fullMethod, first, names...))
+	}
+}
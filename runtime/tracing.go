@@ -0,0 +1,18 @@
+package runtime
+
+import (
+	"google.golang.org/grpc"
+)
+
+// TracingFunc returns the interceptor tracing the gateway calls of fullMethod, it is called once per method
+// when the Register* functions build the chains.
+type TracingFunc func(fullMethod string) grpc.UnaryServerInterceptor
+
+// WithTracingFunc sets the outermost interceptor of the gateway calls, the last one set is used.
+// The code generated with tracing=true sets the OpenTelemetry one with otelpgi.WithTracing.
+func WithTracingFunc(tracing TracingFunc) Option {
+	return func(o *Options) error {
+		o.tracing = tracing
+		return nil
+	}
+}
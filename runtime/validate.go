@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type validatorAll interface {
+	ValidateAll() error
+}
+
+type validator interface {
+	Validate() error
+}
+
+// fieldError is implemented by the field errors of protoc-gen-validate.
+type fieldError interface {
+	Field() string
+	Reason() string
+}
+
+// multiError is implemented by the errors returned from ValidateAll of protoc-gen-validate.
+type multiError interface {
+	AllErrors() []error
+}
+
+// ValidateRequest calls ValidateAll or, if it is missing, Validate of the decoded request.
+// Requests without these methods are not checked. Errors which are not gRPC statuses already
+// are returned as codes.InvalidArgument with the field violations in errdetails.BadRequest.
+func ValidateRequest(req interface{}) error {
+	var err error
+	switch req := req.(type) {
+	case validatorAll:
+		err = req.ValidateAll()
+	case validator:
+		err = req.Validate()
+	default:
+		return nil
+	}
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	st := status.New(codes.InvalidArgument, err.Error())
+	violations := getFieldViolations(err)
+	if len(violations) == 0 {
+		return st.Err()
+	}
+	if withDetails, detailsErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); detailsErr == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+func getFieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var errs []error
+	var multi multiError
+	if errors.As(err, &multi) {
+		errs = multi.AllErrors()
+	} else {
+		errs = []error{err}
+	}
+
+	var resp []*errdetails.BadRequest_FieldViolation
+	for i := range errs {
+		var field fieldError
+		if !errors.As(errs[i], &field) {
+			continue
+		}
+		resp = append(resp, &errdetails.BadRequest_FieldViolation{
+			Field:       field.Field(),
+			Description: field.Reason(),
+		})
+	}
+	return resp
+}
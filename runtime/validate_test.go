@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testFieldError mimics the field errors of protoc-gen-validate.
+type testFieldError struct {
+	field, reason string
+}
+
+func (e testFieldError) Field() string  { return e.field }
+func (e testFieldError) Reason() string { return e.reason }
+func (e testFieldError) Error() string  { return "invalid " + e.field + ": " + e.reason }
+
+// testMultiError mimics the errors of ValidateAll of protoc-gen-validate.
+type testMultiError []error
+
+func (e testMultiError) AllErrors() []error { return e }
+func (e testMultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type validatedRequest struct {
+	err error
+}
+
+func (r validatedRequest) Validate() error { return r.err }
+
+type validatedAllRequest struct {
+	validatedRequest
+	allErr error
+}
+
+func (r validatedAllRequest) ValidateAll() error { return r.allErr }
+
+func TestValidateRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		req        interface{}
+		code       codes.Code
+		violations []string
+	}{
+		{name: "without validation", req: &emptypb.Empty{}, code: codes.OK},
+		{name: "valid", req: validatedRequest{}, code: codes.OK},
+		{name: "plain error", req: validatedRequest{err: errors.New("bad")}, code: codes.InvalidArgument},
+		{
+			name:       "field error",
+			req:        validatedRequest{err: testFieldError{field: "id", reason: "is empty"}},
+			code:       codes.InvalidArgument,
+			violations: []string{"id: is empty"},
+		},
+		{
+			name: "all field errors",
+			req: validatedAllRequest{
+				validatedRequest: validatedRequest{err: errors.New("only the first one")},
+				allErr: testMultiError{
+					testFieldError{field: "id", reason: "is empty"},
+					testFieldError{field: "name", reason: "is too long"},
+				},
+			},
+			code:       codes.InvalidArgument,
+			violations: []string{"id: is empty", "name: is too long"},
+		},
+		{name: "status error", req: validatedRequest{err: status.Error(codes.FailedPrecondition, "not yet")}, code: codes.FailedPrecondition},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRequest(tc.req)
+			st := status.Convert(err)
+			if st.Code() != tc.code {
+				t.Fatalf("code is %s, want %s: %v", st.Code(), tc.code, err)
+			}
+			var violations []string
+			for _, detail := range st.Details() {
+				if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+					for _, violation := range badRequest.GetFieldViolations() {
+						violations = append(violations, violation.GetField()+": "+violation.GetDescription())
+					}
+				}
+			}
+			if !reflect.DeepEqual(violations, tc.violations) {
+				t.Errorf("violations are %q, want %q", violations, tc.violations)
+			}
+		})
+	}
+}